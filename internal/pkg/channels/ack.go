@@ -0,0 +1,190 @@
+package channels
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rotisserie/eris"
+	"github.com/rs/zerolog"
+)
+
+var ErrAlreadyAcknowledged = eris.New("The message is already acknowledged")
+
+const (
+	ackCallbackPrefix = "ack:"
+	ackDefaultText    = "Acknowledge"
+	ackHookTimeout    = 5 * time.Second
+	ackTimeLayout     = "2006-01-02 15:04 MST"
+)
+
+// AckCallbackData encodes the callback data of the Acknowledge button of the message.
+func AckCallbackData(channel string, id string) string {
+	return ackCallbackPrefix + channel + ":" + id
+}
+
+// ParseAckCallbackData decodes the channel name and the message ID from the callback data of the Acknowledge button.
+func ParseAckCallbackData(data string) (string, string, bool) {
+	if !strings.HasPrefix(data, ackCallbackPrefix) {
+		return "", "", false
+	}
+	channel, id, ok := strings.Cut(strings.TrimPrefix(data, ackCallbackPrefix), ":")
+	if !ok || channel == "" || id == "" {
+		return "", "", false
+	}
+	return channel, id, true
+}
+
+// AckEvent is the body of the ack hook request.
+type AckEvent struct {
+	Channel string    `json:"channel"`
+	ID      string    `json:"id"`
+	Key     string    `json:"key,omitempty"`
+	By      string    `json:"by"`
+	At      time.Time `json:"at"`
+	Text    string    `json:"text,omitempty"`
+}
+
+// messageAcks adds the Acknowledge buttons to the messages and calls the hook when the messages are acknowledged.
+// The button is added to all messages with the ack option or to the messages with the "ack" field.
+// Only the Telegram channels send the buttons, the other channels reject the messages with the "ack" field.
+type messageAcks struct {
+	channel    string
+	supported  bool
+	all        bool
+	text       string
+	hookURL    string
+	httpClient *resty.Client
+	logger     *zerolog.Logger
+}
+
+func newMessageAcks(opts map[string]string, channel string, supported bool, logger *zerolog.Logger) (*messageAcks, error) {
+	acks := &messageAcks{
+		channel:   channel,
+		supported: supported,
+		all:       opts["ack"] == "1",
+		text:      ackDefaultText,
+		logger:    logger,
+	}
+
+	_, hasHook := opts["ack_hook"]
+	if (acks.all || hasHook) && !acks.fits() {
+		return nil, eris.Wrapf(ErrInvalidChannelOption, "ack: the channel name %s is too long for the Acknowledge buttons", channel)
+	}
+
+	if val, ok := opts["ack_text"]; ok {
+		acks.text = val
+	}
+
+	if val, ok := opts["ack_hook"]; ok {
+		u, err := url.Parse(val)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, eris.Wrapf(ErrInvalidChannelOption, "ack_hook: %s", val)
+		}
+		acks.hookURL = val
+		acks.httpClient = resty.New().
+			SetTimeout(ackHookTimeout).
+			SetRedirectPolicy(resty.NoRedirectPolicy()).
+			SetRetryCount(httpRetries).
+			SetRetryMaxWaitTime(httpMaxWaitTime).
+			SetHeader("Content-Type", "application/json").
+			AddRetryCondition(
+				func(r *resty.Response, err error) bool {
+					return r.StatusCode() == http.StatusTooManyRequests || r.StatusCode() >= http.StatusInternalServerError
+				},
+			)
+	}
+
+	return acks, nil
+}
+
+// fits reports whether the callback data of the Acknowledge buttons of the channel fits the Telegram limit.
+func (a *messageAcks) fits() bool {
+	return len(AckCallbackData(a.channel, strings.Repeat("0", 2*messageIDBytes))) <= telegramCallbackDataMaxBytes
+}
+
+// validate rejects the message with the Acknowledge button which the channel can't send.
+func (a *messageAcks) validate(message *TelegramMessage) error {
+	switch {
+	case !message.Ack:
+		return nil
+	case !a.supported:
+		return eris.Wrap(ErrInvalidMessage, "the channel doesn't support the Acknowledge buttons")
+	case len(message.Media) > 0:
+		return eris.Wrap(ErrInvalidMessage, "media groups don't support the Acknowledge buttons")
+	case !a.fits():
+		return eris.Wrapf(ErrInvalidMessage, "the channel name %s is too long for the Acknowledge buttons", a.channel)
+	}
+	return nil
+}
+
+// needButton reports whether the message gets the Acknowledge button.
+// Media groups can't have the keyboards, they are sent without it.
+func (a *messageAcks) needButton(message *TelegramMessage) bool {
+	return (a.all || message.Ack) && len(message.Media) == 0
+}
+
+// addButton appends the row with the Acknowledge button to the inline keyboard of the message.
+// It returns false if the message doesn't need the button.
+func (a *messageAcks) addButton(message *TelegramMessage, channel string, id string) bool {
+	if !a.needButton(message) {
+		return false
+	}
+
+	data := AckCallbackData(channel, id)
+	button := []*TelegramInlineKeyboardButton{{Text: a.text, CallbackData: &data}}
+	if message.ReplyMarkup == nil {
+		message.ReplyMarkup = &TelegramInlineKeyboardMarkup{}
+	}
+	message.ReplyMarkup.InlineKeyboard = append(message.ReplyMarkup.InlineKeyboard, button)
+	return true
+}
+
+// acknowledged returns the copy of the message which shows who and when acknowledged it, without the Acknowledge button.
+func (a *messageAcks) acknowledged(message *TelegramMessage, by string, at time.Time) *TelegramMessage {
+	edited := *message
+	edited.Text += "\n\n" + ackNote(by, at, message.ParseMode)
+
+	edited.ReplyMarkup = nil
+	if message.ReplyMarkup != nil {
+		rows := [][]*TelegramInlineKeyboardButton{}
+		for _, row := range message.ReplyMarkup.InlineKeyboard {
+			if len(row) == 1 && row[0].CallbackData != nil && strings.HasPrefix(*row[0].CallbackData, ackCallbackPrefix) {
+				continue
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) > 0 {
+			edited.ReplyMarkup = &TelegramInlineKeyboardMarkup{InlineKeyboard: rows}
+		}
+	}
+
+	return &edited
+}
+
+// callHook posts the event to the ack hook in the background.
+func (a *messageAcks) callHook(event *AckEvent) {
+	if a.httpClient == nil {
+		return
+	}
+
+	go func() {
+		res, err := a.httpClient.R().SetBody(event).Post(a.hookURL)
+		if err == nil && res.IsError() {
+			err = eris.New(fmt.Sprintf("Failed request to ack hook: status: %d, body: %s", res.StatusCode(), res.Body()))
+		}
+		if err != nil {
+			a.logger.Error().Msgf("Failed to call ack hook of message %s: %s", event.ID, err)
+			return
+		}
+		a.logger.Info().Msgf("Ack hook of message %s is called", event.ID)
+	}()
+}
+
+// ackNote is the line added to the acknowledged message, escaped for the parse mode of the message.
+func ackNote(by string, at time.Time, parseMode *string) string {
+	return escapeTelegramText("✅ Acknowledged by "+by+" at "+at.UTC().Format(ackTimeLayout), parseMode)
+}
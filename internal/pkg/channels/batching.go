@@ -0,0 +1,201 @@
+package channels
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchMaxMessages = 50
+	defaultBatchMaxLength   = 4096
+	batchDefaultGroupKey    = "other"
+)
+
+// messageBatcher buffers the messages for a time window and joins them into one digest message.
+// The digest is sent when the window closes, or earlier if the batch reaches the messages limit.
+type messageBatcher struct {
+	mu sync.Mutex
+
+	window      time.Duration
+	maxMessages int
+	maxLength   int
+
+	tasks    []*messageTask
+	deadline time.Time
+}
+
+func newMessageBatcher(opts map[string]string) (*messageBatcher, error) {
+	b := &messageBatcher{
+		maxMessages: defaultBatchMaxMessages,
+		maxLength:   defaultBatchMaxLength,
+	}
+
+	var err error
+	if val, ok := opts["batch_window"]; ok {
+		if b.window, err = ParseDurationOption("batch_window", val); err != nil {
+			return nil, err
+		}
+	}
+	if val, ok := opts["batch_size"]; ok {
+		if b.maxMessages, err = parsePositiveIntOption("batch_size", val); err != nil {
+			return nil, err
+		}
+	}
+	if val, ok := opts["batch_max_length"]; ok {
+		if b.maxLength, err = parsePositiveIntOption("batch_max_length", val); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+func (b *messageBatcher) enabled() bool {
+	return b.window > 0
+}
+
+// accepts checks if the message could be joined with the others.
+// Messages which are addressed later or carry media, buttons or a thread are sent as is.
+func (b *messageBatcher) accepts(task *messageTask) bool {
+	m := task.message
+	return b.enabled() &&
+		task.operation == sendOperation &&
+		m.Key == "" &&
+		m.Topic == "" &&
+		m.MessageThreadID == nil &&
+		m.ReplyToMessageID == nil &&
+		m.ReplyMarkup == nil &&
+		len(m.Media) == 0
+}
+
+// add buffers the task and returns true if the batch is full.
+func (b *messageBatcher) add(task *messageTask) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.tasks) == 0 {
+		b.deadline = time.Now().Add(b.window)
+	}
+	b.tasks = append(b.tasks, task)
+
+	return len(b.tasks) >= b.maxMessages
+}
+
+// due returns the buffered tasks if the window is closed.
+func (b *messageBatcher) due(now time.Time) []*messageTask {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.tasks) == 0 || now.Before(b.deadline) {
+		return nil
+	}
+	return b.takeLocked()
+}
+
+func (b *messageBatcher) take() []*messageTask {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.takeLocked()
+}
+
+func (b *messageBatcher) takeLocked() []*messageTask {
+	tasks := b.tasks
+	b.tasks = nil
+	return tasks
+}
+
+type digestGroup struct {
+	key    string
+	count  int
+	texts  []string
+	counts map[string]int
+}
+
+// digest renders the batch as one HTML message grouped by the grouping keys with the counts.
+func (b *messageBatcher) digest(tasks []*messageTask) *TelegramMessage {
+	groups := []*digestGroup{}
+	groupsMap := map[string]*digestGroup{}
+	notify := false
+	for _, task := range tasks {
+		m := task.message
+		key := m.GroupKey
+		if key == "" {
+			key = batchDefaultGroupKey
+		}
+
+		g, ok := groupsMap[key]
+		if !ok {
+			g = &digestGroup{key: key, counts: map[string]int{}}
+			groupsMap[key] = g
+			groups = append(groups, g)
+		}
+
+		text := m.Text
+		if m.ParseMode == nil || !strings.EqualFold(*m.ParseMode, "HTML") {
+			text = html.EscapeString(text)
+		}
+		if g.counts[text] == 0 {
+			g.texts = append(g.texts, text)
+		}
+		g.counts[text]++
+		g.count++
+
+		notify = notify || m.DisableNotifications == 0
+	}
+
+	lines := []string{
+		fmt.Sprintf("<b>Digest: %d messages</b>", len(tasks)),
+	}
+	length := len([]rune(lines[0]))
+	// Keep a room for the summary of the skipped messages.
+	fits := func(line string) bool {
+		return length+len([]rune(line))+64 <= b.maxLength
+	}
+
+	// Once a line doesn't fit, all next lines are skipped, so the digest keeps the order of the groups.
+	skipped := 0
+	full := false
+	for _, g := range groups {
+		header := fmt.Sprintf("<b>%s</b> (%d):", html.EscapeString(g.key), g.count)
+		if full || !fits(header) {
+			full = true
+			skipped += g.count
+			continue
+		}
+		lines = append(lines, "", header)
+		length += len([]rune(header)) + 2
+
+		for _, text := range g.texts {
+			line := "• " + text
+			if n := g.counts[text]; n > 1 {
+				line += fmt.Sprintf(" ×%d", n)
+			}
+			if full || !fits(line) {
+				full = true
+				skipped += g.counts[text]
+				continue
+			}
+			lines = append(lines, line)
+			length += len([]rune(line)) + 1
+		}
+	}
+	if skipped > 0 {
+		lines = append(lines, "", fmt.Sprintf("… and %d more messages", skipped))
+	}
+
+	parseMode := "HTML"
+	message := &TelegramMessage{
+		Text:                  strings.Join(lines, "\n"),
+		ParseMode:             &parseMode,
+		DisableWebPagePreview: 1,
+	}
+	if !notify {
+		message.DisableNotifications = 1
+	}
+
+	return message
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"time"
 
 	"github.com/rotisserie/eris"
 	"github.com/rs/zerolog"
@@ -12,13 +14,23 @@ import (
 var (
 	ErrUnknownChannelType = eris.New("Unknown channel type")
 	ErrCreateChannelErorr = eris.New("Error on create channel")
+	ErrMessageNotFound    = eris.New("Message not found")
+
+	ErrOperationNotSupported = eris.New("The channel doesn't support the operation")
 )
 
+// _reservedChannelNames are the paths of the HTTP API, the channels and the groups with these names are unreachable.
+var _reservedChannelNames = map[string]bool{
+	"ping":    true,
+	"route":   true,
+	"updates": true,
+}
+
 type MessageChannelInterface interface {
 	fmt.Stringer
 	Name() string
 	MessageContainer() interface{}
-	Enqueue(interface{}) error
+	Enqueue(interface{}) (string, error)
 	Provider() interface {
 		HTTPClient() *http.Client
 	}
@@ -26,10 +38,56 @@ type MessageChannelInterface interface {
 	Start() error
 }
 
+// MessageEditorInterface is implemented by the channels which can edit and delete the sent messages.
+// Messages are addressed by the ID returned from Enqueue or by the client correlation key.
+type MessageEditorInterface interface {
+	EnqueueEdit(id string, message interface{}) error
+	EnqueueDelete(id string) error
+}
+
+// MessageSchedulerInterface is implemented by the channels which hold the messages for the future delivery.
+type MessageSchedulerInterface interface {
+	Scheduled() []*ScheduledMessage
+	CancelScheduled(id string) error
+}
+
+// MessageStatsInterface is implemented by the channels which count the processed messages.
+type MessageStatsInterface interface {
+	Stats() *ChannelStats
+}
+
+// MessageStatusInterface is implemented by the channels which track the delivery of the enqueued messages.
+type MessageStatusInterface interface {
+	MessageStatus(idOrKey string) (*MessageStatus, error)
+}
+
+// AcknowledgerInterface is implemented by the channels which add the Acknowledge buttons to the messages.
+// SentBy tells whether the messages are sent by the bot with the token: only its receiver gets the presses of their buttons.
+type AcknowledgerInterface interface {
+	Acknowledge(idOrKey string, by string, at time.Time) error
+	SentBy(botToken string) bool
+}
+
+// BackpressureInterface is implemented by the channels which estimate when the full queue will have room.
+type BackpressureInterface interface {
+	RetryAfter() time.Duration
+}
+
+// DeadLettersInterface is implemented by the channels which keep the dropped messages.
+type DeadLettersInterface interface {
+	DeadLetters() ([]*DeadLetter, error)
+}
+
+// FilesContainerInterface is implemented by the message containers which accept uploaded files.
+type FilesContainerInterface interface {
+	AttachFile(name string, fileName string, content []byte) error
+}
+
 var _channelsTypes = map[string]func(chanURL *url.URL, logger *zerolog.Logger) (MessageChannelInterface, error){}
 
 func BuildChannelsFromURLS(urls []string, logger *zerolog.Logger) ([]MessageChannelInterface, error) {
 	result := []MessageChannelInterface{}
+	statePaths := map[string]string{}
 	for _, chanURL := range urls {
 		u, err := url.Parse(chanURL)
 		if err != nil {
@@ -39,8 +97,27 @@ func BuildChannelsFromURLS(urls []string, logger *zerolog.Logger) ([]MessageChan
 		if err != nil {
 			return nil, err
 		}
+		if _reservedChannelNames[ch.Name()] {
+			return nil, eris.Wrapf(ErrCreateChannelErorr, "channel name %s is reserved by the HTTP API", ch.Name())
+		}
+
+		// The buckets of the state aren't separated by the channels, every channel needs its own file.
+		if qch, ok := ch.(*queuedChannel); ok {
+			if path, ok := qch.options["state"]; ok {
+				absPath, _ := filepath.Abs(path)
+				if other, ok := statePaths[absPath]; ok {
+					return nil, eris.Wrapf(ErrInvalidChannelOption, "state: %s is used by the channels %s and %s", path, other, qch.name)
+				}
+				statePaths[absPath] = qch.name
+			}
+		}
+
 		result = append(result, ch)
 	}
+
+	if err := linkFallbacks(result); err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
@@ -58,4 +135,12 @@ func BuildChannel(chanURL *url.URL, logger *zerolog.Logger) (MessageChannelInter
 
 func init() {
 	_channelsTypes["telegram"] = NewTelegramChannel
+	_channelsTypes["slack"] = NewSlackChannel
+	_channelsTypes["discord"] = NewDiscordChannel
+	_channelsTypes["smtp"] = NewSMTPChannel
+	_channelsTypes["webhook"] = NewWebhookChannel
+	_channelsTypes["http+webhook"] = NewWebhookChannel
+	_channelsTypes["matrix"] = NewMatrixChannel
+	_channelsTypes["file"] = NewFileChannel
+	_channelsTypes["stdout"] = NewStdoutChannel
 }
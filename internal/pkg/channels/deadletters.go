@@ -0,0 +1,100 @@
+package channels
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/storage"
+)
+
+// DeadLettersCap limits the number of the kept dead letters, the oldest ones are dropped.
+var DeadLettersCap = 1000
+
+const deadLettersStateBucket = "dead_letters"
+
+// Reasons of the dead letters.
+const (
+	DeadLetterExpired = "expired"
+	DeadLetterShed    = "shed"
+)
+
+// DeadLetter is a message dropped by the channel without delivery.
+type DeadLetter struct {
+	ID      string           `json:"id"`
+	Reason  string           `json:"reason"`
+	At      time.Time        `json:"at"`
+	Message *TelegramMessage `json:"message"`
+}
+
+// deadLetterStore keeps the dropped messages in the channel state in the order of dropping.
+// The store is disabled unless the dead_letters option is set.
+type deadLetterStore struct {
+	mu      sync.Mutex
+	enabled bool
+	state   storage.Storage
+}
+
+func newDeadLetterStore(opts map[string]string, state storage.Storage) (*deadLetterStore, error) {
+	store := &deadLetterStore{state: state}
+
+	if val, ok := opts["dead_letters"]; ok {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, eris.Wrapf(ErrInvalidChannelOption, "dead_letters: %s", val)
+		}
+		store.enabled = enabled
+	}
+
+	return store, nil
+}
+
+func (s *deadLetterStore) add(id string, reason string, message *TelegramMessage) error {
+	if !s.enabled {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl := &DeadLetter{ID: id, Reason: reason, At: time.Now(), Message: message}
+	if err := s.state.Put(deadLettersStateBucket, fmt.Sprintf("%020d-%s", dl.At.UnixNano(), id), dl); err != nil {
+		return err
+	}
+
+	keys, err := s.state.Keys(deadLettersStateBucket)
+	if err != nil {
+		return err
+	}
+	for len(keys) > DeadLettersCap {
+		if err := s.state.Delete(deadLettersStateBucket, keys[0]); err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+
+	return nil
+}
+
+func (s *deadLetterStore) list() ([]*DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.state.Keys(deadLettersStateBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*DeadLetter, 0, len(keys))
+	for _, key := range keys {
+		dl := &DeadLetter{}
+		if _, err := s.state.Get(deadLettersStateBucket, key, dl); err != nil {
+			return nil, err
+		}
+		res = append(res, dl)
+	}
+	return res, nil
+}
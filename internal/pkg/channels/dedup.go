@@ -0,0 +1,177 @@
+package channels
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rotisserie/eris"
+)
+
+const (
+	defaultDedupCapacity = 1000
+	repeatsSnippetLength = 100
+
+	dedupModeFollowup = "followup"
+	dedupModeEdit     = "edit"
+)
+
+// dedupEntry tracks the repeats of a message during the suppression window.
+type dedupEntry struct {
+	key     string
+	task    *messageTask
+	expires time.Time
+	repeats int
+}
+
+// messageDeduplicator suppresses identical messages or messages with the same dedup key within a window.
+// The entries are kept in a bounded LRU list, so the memory doesn't grow without limit.
+type messageDeduplicator struct {
+	mu sync.Mutex
+
+	window   time.Duration
+	capacity int
+	mode     string
+
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newMessageDeduplicator(opts map[string]string) (*messageDeduplicator, error) {
+	d := &messageDeduplicator{
+		capacity: defaultDedupCapacity,
+		mode:     dedupModeFollowup,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+
+	var err error
+	if val, ok := opts["dedup_window"]; ok {
+		if d.window, err = ParseDurationOption("dedup_window", val); err != nil {
+			return nil, err
+		}
+	}
+	if val, ok := opts["dedup_size"]; ok {
+		if d.capacity, err = parsePositiveIntOption("dedup_size", val); err != nil {
+			return nil, err
+		}
+	}
+	if val, ok := opts["dedup_mode"]; ok {
+		if val != dedupModeFollowup && val != dedupModeEdit {
+			return nil, eris.Wrapf(ErrInvalidChannelOption, "dedup_mode: %s", val)
+		}
+		d.mode = val
+	}
+
+	return d, nil
+}
+
+func (d *messageDeduplicator) enabled() bool {
+	return d.window > 0
+}
+
+func dedupKey(m *TelegramMessage) string {
+	if m.DedupKey != "" {
+		return "key:" + m.DedupKey
+	}
+
+	h := sha256.New()
+	parts := []string{m.Text}
+	if m.ParseMode != nil {
+		parts = append(parts, *m.ParseMode)
+	}
+	for _, item := range m.Media {
+		parts = append(parts, item.Type, item.Media)
+	}
+	h.Write([]byte(strings.Join(parts, "\x00")))
+	return "hash:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// suppress registers the message and checks if it repeats a message sent within the window.
+// The entries evicted from the full list are returned to report their repeats.
+func (d *messageDeduplicator) suppress(task *messageTask, now time.Time) (bool, *dedupEntry, []*dedupEntry) {
+	if !d.enabled() || task.operation != sendOperation {
+		return false, nil, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := dedupKey(task.message)
+	if el, ok := d.entries[key]; ok {
+		entry := el.Value.(*dedupEntry) //nolint:forcetypeassert
+		if now.Before(entry.expires) {
+			entry.repeats++
+			return true, entry, nil
+		}
+	}
+
+	d.entries[key] = d.order.PushBack(&dedupEntry{
+		key:     key,
+		task:    task,
+		expires: now.Add(d.window),
+	})
+
+	evicted := []*dedupEntry{}
+	for d.order.Len() > d.capacity {
+		entry := d.removeLocked(d.order.Front())
+		if entry.repeats > 0 {
+			evicted = append(evicted, entry)
+		}
+	}
+
+	return false, nil, evicted
+}
+
+// expired removes the entries with the closed windows and returns the ones with repeats.
+// All windows have the same length, so the list is ordered by the expiration time.
+func (d *messageDeduplicator) expired(now time.Time) []*dedupEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := []*dedupEntry{}
+	for el := d.order.Front(); el != nil; el = d.order.Front() {
+		entry := el.Value.(*dedupEntry) //nolint:forcetypeassert
+		if now.Before(entry.expires) {
+			break
+		}
+		d.removeLocked(el)
+		if entry.repeats > 0 {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+func (d *messageDeduplicator) removeLocked(el *list.Element) *dedupEntry {
+	entry := d.order.Remove(el).(*dedupEntry) //nolint:forcetypeassert
+	if current, ok := d.entries[entry.key]; ok && current == el {
+		delete(d.entries, entry.key)
+	}
+	return entry
+}
+
+// repeatsNote is appended to the edited original message.
+func repeatsNote(repeats int, parseMode *string) string {
+	return escapeTelegramText("(repeated "+repeatsCount(repeats)+")", parseMode)
+}
+
+func truncateText(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length]) + "…"
+}
+
+func repeatsCount(repeats int) string {
+	if repeats == 1 {
+		return "1 time"
+	}
+	return fmt.Sprintf("%d times", repeats)
+}
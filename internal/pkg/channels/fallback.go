@@ -0,0 +1,183 @@
+package channels
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rotisserie/eris"
+)
+
+var (
+	ErrInvalidFallback = eris.New("Invalid fallback channel")
+	ErrCircuitOpen     = eris.New("The channel circuit is open")
+)
+
+const (
+	defaultCircuitFailures = 5
+	defaultCircuitTimeout  = time.Minute
+)
+
+// circuitBreaker stops the sends by the provider of the channel after the consecutive failures.
+// The open circuit lets one message through after the timeout, the success closes it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	timeout   time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(options map[string]string) (*circuitBreaker, error) {
+	cb := &circuitBreaker{
+		threshold: defaultCircuitFailures,
+		timeout:   defaultCircuitTimeout,
+	}
+
+	var err error
+	if val, ok := options["circuit_failures"]; ok {
+		if cb.threshold, err = parsePositiveIntOption("circuit_failures", val); err != nil {
+			return nil, err
+		}
+	}
+	if val, ok := options["circuit_timeout"]; ok {
+		if cb.timeout, err = ParseDurationOption("circuit_timeout", val); err != nil {
+			return nil, err
+		}
+	}
+
+	return cb, nil
+}
+
+// allow reports whether the circuit is closed or its timeout is over.
+func (cb *circuitBreaker) allow(now time.Time) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return !now.Before(cb.openUntil)
+}
+
+func (cb *circuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures = 0
+	cb.openUntil = time.Time{}
+}
+
+// failure counts the failed send and reports whether the circuit has been opened.
+func (cb *circuitBreaker) failure(now time.Time) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.failures < cb.threshold {
+		return false
+	}
+	cb.openUntil = now.Add(cb.timeout)
+	return true
+}
+
+// linkFallbacks resolves the names from the fallback options of the channels.
+// The fallbacks deliver only by their own providers, so the chains can't loop.
+func linkFallbacks(channels []MessageChannelInterface) error {
+	channelsMap := map[string]*queuedChannel{}
+	for _, ch := range channels {
+		if qch, ok := ch.(*queuedChannel); ok {
+			channelsMap[qch.Name()] = qch
+		}
+	}
+
+	for _, ch := range channelsMap {
+		names, ok := ch.options["fallback"]
+		if !ok {
+			continue
+		}
+
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			fallback, ok := channelsMap[name]
+			if !ok {
+				return eris.Wrapf(ErrInvalidFallback, "channel %s: unknown fallback %s", ch.name, name)
+			}
+			if fallback == ch {
+				return eris.Wrapf(ErrInvalidFallback, "channel %s is its own fallback", ch.name)
+			}
+			ch.fallbacks = append(ch.fallbacks, fallback)
+		}
+	}
+
+	return nil
+}
+
+// sendWithFallbacks sends the message by the provider of the channel, then by the fallback channels in order.
+// The provider of the channel with fallbacks is skipped while its circuit is open.
+// It returns the name of the channel which delivered the message.
+func (ch *queuedChannel) sendWithFallbacks(task *messageTask) (string, error) {
+	original := *task.message
+
+	var err error
+	if len(ch.fallbacks) == 0 || ch.circuit.allow(time.Now()) {
+		err = ch.sendTask(task)
+		if err == nil {
+			ch.circuit.success()
+			ch.registry.setDeliveredBy(task.id, ch.name)
+			return ch.name, nil
+		}
+		if len(ch.fallbacks) > 0 && ch.circuit.failure(time.Now()) {
+			ch.logger.Warn().Msgf("The circuit of the channel %s is open for %s", ch.name, ch.circuit.timeout)
+		}
+	} else {
+		err = eris.Wrapf(ErrCircuitOpen, "channel %s", ch.name)
+	}
+
+	for _, fallback := range ch.fallbacks {
+		ch.logger.Warn().Msgf("Failed to send message %s, trying the fallback channel %s: %s", task.id, fallback.name, err)
+
+		// Every fallback gets the message before the topic of the previous channel is resolved.
+		message := original
+		if fbErr := fallback.deliver(task.id, &message); fbErr != nil {
+			err = fbErr
+			continue
+		}
+		ch.registry.setDeliveredBy(task.id, fallback.name)
+		return fallback.name, nil
+	}
+
+	ch.registry.setFailed(task.id)
+	return "", err
+}
+
+// deliver sends the message of another channel by the provider of the channel, bypassing the queue.
+// The message is sent from the worker of the failed channel right away: the stats, TTL, quiet hours
+// and the messages order of the fallback channel don't apply to it.
+// The channels of other services get the message without the Telegram-only fields, so the alerts with
+// the keyboards, the Acknowledge buttons or the forum topics can fail over to them.
+func (ch *queuedChannel) deliver(id string, message *TelegramMessage) error {
+	if _, ok := ch.provider.(*telegramChat); !ok {
+		stripTelegramFields(message)
+	}
+	if validator, ok := ch.provider.(messageValidatorProviderInterface); ok {
+		if err := validator.ValidateMessage(message); err != nil {
+			return err
+		}
+	}
+	if err := ch.topics.resolve(message); err != nil {
+		return err
+	}
+
+	_, err := ch.sendMessage(id, message)
+	return err
+}
+
+// stripTelegramFields removes the inline keyboard, the Acknowledge button, the forum topic and the reply from the message.
+func stripTelegramFields(message *TelegramMessage) {
+	message.ReplyMarkup = nil
+	message.Ack = false
+	message.Topic = ""
+	message.MessageThreadID = nil
+	message.ReplyToMessageID = nil
+}
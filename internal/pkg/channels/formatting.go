@@ -0,0 +1,551 @@
+package channels
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Styles of the formatted text nodes.
+const (
+	richText      = ""
+	richBold      = "bold"
+	richItalic    = "italic"
+	richUnderline = "underline"
+	richStrike    = "strike"
+	richSpoiler   = "spoiler"
+	richCode      = "code"
+	richPre       = "pre"
+	richLink      = "link"
+	richQuote     = "quote"
+)
+
+// richNode is a node of the text formatted with the Telegram HTML or Markdown.
+// The channels of other services render the nodes into their own markup.
+type richNode struct {
+	style    string
+	text     string
+	url      string
+	language string
+	children []*richNode
+}
+
+// parseRichText parses the text of the message according to its parse mode.
+func parseRichText(text string, parseMode *string) []*richNode {
+	switch {
+	case parseMode == nil:
+		return []*richNode{{text: text}}
+	case strings.EqualFold(*parseMode, "HTML"):
+		return parseTelegramHTML(text)
+	case strings.EqualFold(*parseMode, "MarkdownV2"):
+		return (&markdownParser{text: []rune(text), v2: true}).parse("")
+	case strings.EqualFold(*parseMode, "Markdown"):
+		return (&markdownParser{text: []rune(text)}).parse("")
+	default:
+		return []*richNode{{text: text}}
+	}
+}
+
+var (
+	_htmlTagRe  = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)([^>]*)>`)
+	_htmlAttrRe = regexp.MustCompile(`([a-zA-Z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+var _htmlTagStyles = map[string]string{
+	"b":          richBold,
+	"strong":     richBold,
+	"i":          richItalic,
+	"em":         richItalic,
+	"u":          richUnderline,
+	"ins":        richUnderline,
+	"s":          richStrike,
+	"strike":     richStrike,
+	"del":        richStrike,
+	"tg-spoiler": richSpoiler,
+	"code":       richCode,
+	"pre":        richPre,
+	"a":          richLink,
+	"blockquote": richQuote,
+}
+
+// parseTelegramHTML parses the subset of HTML supported by Telegram.
+// Unknown tags keep their content, unclosed tags are closed at the end of the text.
+func parseTelegramHTML(text string) []*richNode {
+	root := &richNode{}
+	stack := []*richNode{root}
+	tags := []string{""}
+
+	appendText := func(s string) {
+		if s == "" {
+			return
+		}
+		top := stack[len(stack)-1]
+		top.children = append(top.children, &richNode{text: html.UnescapeString(s)})
+	}
+
+	pos := 0
+	for _, m := range _htmlTagRe.FindAllStringSubmatchIndex(text, -1) {
+		appendText(text[pos:m[0]])
+		pos = m[1]
+
+		closing := m[3] > m[2]
+		tag := strings.ToLower(text[m[4]:m[5]])
+		if closing {
+			for i := len(tags) - 1; i > 0; i-- {
+				if tags[i] == tag {
+					stack, tags = stack[:i], tags[:i]
+					break
+				}
+			}
+			continue
+		}
+
+		attrs := map[string]string{}
+		for _, a := range _htmlAttrRe.FindAllStringSubmatch(text[m[6]:m[7]], -1) {
+			attrs[strings.ToLower(a[1])] = html.UnescapeString(a[2] + a[3])
+		}
+
+		node := &richNode{style: _htmlTagStyles[tag]}
+		switch {
+		case tag == "a":
+			node.url = attrs["href"]
+		case tag == "span" && attrs["class"] == "tg-spoiler":
+			node.style = richSpoiler
+		case tag == "code":
+			parent := stack[len(stack)-1]
+			if parent.style == richPre {
+				// <pre><code class="language-go"> is a code block with the language.
+				parent.language = strings.TrimPrefix(attrs["class"], "language-")
+				node.style = richText
+			}
+		}
+
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, node)
+		stack, tags = append(stack, node), append(tags, tag)
+	}
+	appendText(text[pos:])
+
+	return root.children
+}
+
+// markdownParser parses the Telegram Markdown and MarkdownV2 entities.
+type markdownParser struct {
+	text []rune
+	pos  int
+	v2   bool
+}
+
+var _markdownMarkers = []struct {
+	marker string
+	style  string
+	v2     bool
+}{
+	{"```", richPre, false},
+	{"||", richSpoiler, true},
+	{"__", richUnderline, true},
+	{"*", richBold, false},
+	{"_", richItalic, false},
+	{"~", richStrike, true},
+	{"`", richCode, false},
+}
+
+// parse reads the nodes until the closing marker or the end of the text.
+func (p *markdownParser) parse(closing string) []*richNode {
+	nodes := []*richNode{}
+	buf := strings.Builder{}
+	flush := func() {
+		if buf.Len() > 0 {
+			nodes = append(nodes, &richNode{text: buf.String()})
+			buf.Reset()
+		}
+	}
+
+	for p.pos < len(p.text) {
+		if closing != "" && p.hasPrefix(closing) {
+			p.pos += len([]rune(closing))
+			flush()
+			return nodes
+		}
+
+		c := p.text[p.pos]
+		if c == '\\' && p.pos+1 < len(p.text) {
+			buf.WriteRune(p.text[p.pos+1])
+			p.pos += 2
+			continue
+		}
+
+		if c == '[' {
+			if link, ok := p.parseLink(); ok {
+				flush()
+				nodes = append(nodes, link)
+				continue
+			}
+		}
+
+		if c == '>' && p.v2 && (p.pos == 0 || p.text[p.pos-1] == '\n') {
+			flush()
+			nodes = append(nodes, p.parseQuote())
+			continue
+		}
+
+		if node, ok := p.parseEntity(); ok {
+			flush()
+			nodes = append(nodes, node)
+			continue
+		}
+
+		buf.WriteRune(c)
+		p.pos++
+	}
+
+	flush()
+	return nodes
+}
+
+func (p *markdownParser) parseEntity() (*richNode, bool) {
+	for _, m := range _markdownMarkers {
+		if (m.v2 && !p.v2) || !p.hasPrefix(m.marker) {
+			continue
+		}
+		p.pos += len([]rune(m.marker))
+
+		switch m.style {
+		case richPre:
+			content := p.readUntil(m.marker)
+			node := &richNode{style: richPre, text: content}
+			if lang, code, ok := strings.Cut(content, "\n"); ok && !strings.ContainsAny(lang, " \t") {
+				node.language, node.text = lang, code
+			}
+			return node, true
+		case richCode:
+			return &richNode{style: richCode, text: p.readUntil(m.marker)}, true
+		default:
+			return &richNode{style: m.style, children: p.parse(m.marker)}, true
+		}
+	}
+	return nil, false
+}
+
+// parseLink parses [text](url).
+func (p *markdownParser) parseLink() (*richNode, bool) {
+	start := p.pos
+	p.pos++
+	children := p.parse("]")
+	if p.text[p.pos-1] != ']' || !p.hasPrefix("(") {
+		p.pos = start
+		return nil, false
+	}
+	p.pos++
+	return &richNode{style: richLink, url: p.readUntil(")"), children: children}, true
+}
+
+func (p *markdownParser) parseQuote() *richNode {
+	quote := &richNode{style: richQuote}
+	for p.pos < len(p.text) && p.text[p.pos] == '>' {
+		p.pos++
+		end := p.pos
+		for end < len(p.text) && p.text[end] != '\n' {
+			end++
+		}
+		line := &markdownParser{text: p.text[p.pos:end], v2: p.v2}
+		if len(quote.children) > 0 {
+			quote.children = append(quote.children, &richNode{text: "\n"})
+		}
+		quote.children = append(quote.children, line.parse("")...)
+		p.pos = end
+		if p.pos < len(p.text) && p.pos+1 < len(p.text) && p.text[p.pos+1] == '>' {
+			p.pos++
+		}
+	}
+	return quote
+}
+
+// readUntil reads the text without entities until the closing marker, the escapes are applied.
+func (p *markdownParser) readUntil(closing string) string {
+	buf := strings.Builder{}
+	for p.pos < len(p.text) {
+		if p.hasPrefix(closing) {
+			p.pos += len([]rune(closing))
+			break
+		}
+		if p.text[p.pos] == '\\' && p.pos+1 < len(p.text) {
+			p.pos++
+		}
+		buf.WriteRune(p.text[p.pos])
+		p.pos++
+	}
+	return buf.String()
+}
+
+// hasPrefix compares the text at the position with the prefix in place, without copying the rest of the text.
+func (p *markdownParser) hasPrefix(s string) bool {
+	i := p.pos
+	for _, r := range s {
+		if i >= len(p.text) || p.text[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// richPlainText renders the nodes into a plain text, the links are followed by their URLs.
+func richPlainText(nodes []*richNode) string {
+	buf := strings.Builder{}
+	for _, n := range nodes {
+		content := n.text + richPlainText(n.children)
+		switch n.style {
+		case richLink:
+			if n.url != "" && n.url != content {
+				content += " (" + n.url + ")"
+			}
+		case richQuote:
+			content = prefixLines(content, "> ")
+		}
+		buf.WriteString(content)
+	}
+	return buf.String()
+}
+
+// richSlackMrkdwn renders the nodes into the Slack mrkdwn.
+// Slack has no underline and spoilers, their content is kept as is.
+func richSlackMrkdwn(nodes []*richNode) string {
+	buf := strings.Builder{}
+	for _, n := range nodes {
+		content := slackEscape(n.text) + richSlackMrkdwn(n.children)
+		switch n.style {
+		case richBold:
+			content = "*" + content + "*"
+		case richItalic:
+			content = "_" + content + "_"
+		case richStrike:
+			content = "~" + content + "~"
+		case richCode:
+			content = "`" + content + "`"
+		case richPre:
+			content = "```" + content + "```"
+		case richLink:
+			if n.url != "" {
+				content = "<" + slackEscape(n.url) + "|" + content + ">"
+			}
+		case richQuote:
+			content = prefixLines(content, "> ")
+		}
+		buf.WriteString(content)
+	}
+	return buf.String()
+}
+
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func prefixLines(s string, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
+
+// richDiscordMarkdown renders the nodes into the Discord markdown.
+func richDiscordMarkdown(nodes []*richNode) string {
+	buf := strings.Builder{}
+	for _, n := range nodes {
+		content := discordEscape(n.text) + richDiscordMarkdown(n.children)
+		switch n.style {
+		case richBold:
+			content = "**" + content + "**"
+		case richItalic:
+			content = "*" + content + "*"
+		case richUnderline:
+			content = "__" + content + "__"
+		case richStrike:
+			content = "~~" + content + "~~"
+		case richSpoiler:
+			content = "||" + content + "||"
+		case richCode:
+			content = "`" + n.text + richPlainText(n.children) + "`"
+		case richPre:
+			content = "```" + n.language + "\n" + strings.TrimSuffix(n.text+richPlainText(n.children), "\n") + "\n```"
+		case richLink:
+			if n.url != "" {
+				content = "[" + content + "](" + n.url + ")"
+			}
+		case richQuote:
+			content = prefixLines(content, "> ")
+		}
+		buf.WriteString(content)
+	}
+	return buf.String()
+}
+
+var _discordEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`, ">", `\>`, "[", `\[`, "]", `\]`,
+)
+
+func discordEscape(s string) string {
+	return _discordEscaper.Replace(s)
+}
+
+// _markdownV2Reserved are the characters escaped in the plain text of the Telegram MarkdownV2 messages.
+const _markdownV2Reserved = "_*[]()~`>#+-=|{}.!\\"
+
+var _markdownV2Escaper = func() *strings.Replacer {
+	pairs := []string{}
+	for _, r := range _markdownV2Reserved {
+		pairs = append(pairs, string(r), `\`+string(r))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+var _markdownEscaper = strings.NewReplacer("_", `\_`, "*", `\*`, "`", "\\`", "[", `\[`)
+
+// escapeTelegramText escapes the plain text which is added to the Telegram message with the parse mode.
+func escapeTelegramText(text string, parseMode *string) string {
+	if parseMode == nil {
+		return text
+	}
+
+	switch strings.ToLower(*parseMode) {
+	case "html":
+		return html.EscapeString(text)
+	case "markdownv2":
+		return _markdownV2Escaper.Replace(text)
+	case "markdown":
+		return _markdownEscaper.Replace(text)
+	}
+	return text
+}
+
+const codeFence = "```"
+
+// splitText splits the text into parts of at most limit characters at the line breaks.
+// A fenced code block which doesn't fit is closed at the end of the part and reopened in the next one,
+// only the lines longer than the limit are cut in the middle.
+func splitText(text string, limit int) []string {
+	if utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+
+	parts := []string{}
+	lines := []string{}
+	size := 0
+	fence := ""
+	reopened := false
+
+	// flush ends the part before the current line, the open code block continues in the next part.
+	flush := func() {
+		part := strings.Join(lines, "\n")
+		if fence != "" {
+			part += "\n" + codeFence
+		}
+		parts = append(parts, part)
+
+		lines, size, reopened = nil, 0, false
+		if fence != "" {
+			lines, size, reopened = []string{fence}, utf8.RuneCountInString(fence), true
+		}
+	}
+	hasContent := func() bool {
+		return len(lines) > 0 && !(reopened && len(lines) == 1)
+	}
+	fenceOverhead := func(fence string) int {
+		if fence == "" {
+			return 0
+		}
+		return utf8.RuneCountInString(fence) + 2 + len(codeFence)
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		fenceAfter := fence
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, codeFence) {
+			if fence == "" {
+				fenceAfter = trimmed
+			} else {
+				fenceAfter = ""
+			}
+		}
+
+		if runes := []rune(line); len(runes) > limit-fenceOverhead(fence) {
+			if hasContent() {
+				flush()
+			}
+			chunk := max(limit-fenceOverhead(fence), 1)
+			for len(runes) > chunk {
+				lines = append(lines, string(runes[:chunk]))
+				flush()
+				runes = runes[chunk:]
+			}
+			line = string(runes)
+		}
+
+		need := utf8.RuneCountInString(line)
+		if len(lines) > 0 {
+			need++
+		}
+		closing := 0
+		if fenceAfter != "" {
+			closing = 1 + len(codeFence)
+		}
+		if hasContent() && size+need+closing > limit {
+			flush()
+			need = utf8.RuneCountInString(line)
+			if len(lines) > 0 {
+				need++
+			}
+		}
+
+		lines = append(lines, line)
+		size += need
+		fence = fenceAfter
+	}
+	if hasContent() {
+		parts = append(parts, strings.Join(lines, "\n"))
+	}
+	return parts
+}
+
+var _richHTMLTags = map[string]string{
+	richBold:      "b",
+	richItalic:    "i",
+	richUnderline: "u",
+	richStrike:    "s",
+	richCode:      "code",
+	richQuote:     "blockquote",
+}
+
+// richHTML renders the nodes into a generic HTML, the line breaks outside of code blocks become <br>.
+func richHTML(nodes []*richNode) string {
+	return renderRichHTML(nodes, false)
+}
+
+func renderRichHTML(nodes []*richNode, pre bool) string {
+	buf := strings.Builder{}
+	for _, n := range nodes {
+		text := html.EscapeString(n.text)
+		if !pre && n.style != richPre {
+			text = strings.ReplaceAll(text, "\n", "<br>\n")
+		}
+		content := text + renderRichHTML(n.children, pre || n.style == richPre)
+
+		switch n.style {
+		case richSpoiler:
+			content = `<span data-mx-spoiler="">` + content + "</span>"
+		case richPre:
+			class := ""
+			if n.language != "" {
+				class = ` class="language-` + html.EscapeString(n.language) + `"`
+			}
+			content = "<pre><code" + class + ">" + content + "</code></pre>"
+		case richLink:
+			if n.url != "" {
+				content = `<a href="` + html.EscapeString(n.url) + `">` + content + "</a>"
+			}
+		default:
+			if tag, ok := _richHTMLTags[n.style]; ok {
+				content = "<" + tag + ">" + content + "</" + tag + ">"
+			}
+		}
+		buf.WriteString(content)
+	}
+	return buf.String()
+}
@@ -0,0 +1,138 @@
+package channels
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rotisserie/eris"
+)
+
+var ErrInvalidChannelGroup = eris.New("Invalid channel group")
+
+// ChannelGroup fans out messages to several channels of any type.
+type ChannelGroup struct {
+	name    string
+	members []MessageChannelInterface
+}
+
+// GroupMemberResult is a result of enqueueing a message on a member of the group.
+// RetryAfter is set when the message is rejected by the full queue of the member.
+type GroupMemberResult struct {
+	Channel    string
+	ID         string
+	Err        error
+	RetryAfter time.Duration
+}
+
+func NewChannelGroup(name string, members []MessageChannelInterface) (*ChannelGroup, error) {
+	if name == "" {
+		return nil, eris.Wrap(ErrInvalidChannelGroup, "group name is empty")
+	}
+	if len(members) == 0 {
+		return nil, eris.Wrapf(ErrInvalidChannelGroup, "group %s has no members", name)
+	}
+
+	seen := map[string]bool{}
+	for _, ch := range members {
+		if seen[ch.Name()] {
+			return nil, eris.Wrapf(ErrInvalidChannelGroup, "group %s has duplicated member %s", name, ch.Name())
+		}
+		seen[ch.Name()] = true
+	}
+
+	return &ChannelGroup{
+		name:    name,
+		members: members,
+	}, nil
+}
+
+// BuildChannelGroups builds groups from the specs formatted as "group_name=channel_1,channel_2".
+func BuildChannelGroups(specs []string, channels []MessageChannelInterface) ([]*ChannelGroup, error) {
+	channelsMap := map[string]MessageChannelInterface{}
+	for _, ch := range channels {
+		channelsMap[ch.Name()] = ch
+	}
+
+	result := []*ChannelGroup{}
+	groupNames := map[string]bool{}
+	for _, spec := range specs {
+		name, membersList, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, eris.Wrapf(ErrInvalidChannelGroup, "spec: %s", spec)
+		}
+		name = strings.TrimSpace(name)
+		if _, ok := channelsMap[name]; ok || groupNames[name] {
+			return nil, eris.Wrapf(ErrInvalidChannelGroup, "name %s is already used", name)
+		}
+		if _reservedChannelNames[name] {
+			return nil, eris.Wrapf(ErrInvalidChannelGroup, "name %s is reserved by the HTTP API", name)
+		}
+
+		members := []MessageChannelInterface{}
+		for _, memberName := range strings.Split(membersList, ",") {
+			memberName = strings.TrimSpace(memberName)
+			if memberName == "" {
+				continue
+			}
+			ch, ok := channelsMap[memberName]
+			if !ok {
+				return nil, eris.Wrapf(ErrInvalidChannelGroup, "group %s: unknown channel %s", name, memberName)
+			}
+			members = append(members, ch)
+		}
+
+		group, err := NewChannelGroup(name, members)
+		if err != nil {
+			return nil, err
+		}
+		groupNames[name] = true
+		result = append(result, group)
+	}
+
+	return result, nil
+}
+
+func (g *ChannelGroup) Name() string {
+	return g.name
+}
+
+func (g *ChannelGroup) Members() []MessageChannelInterface {
+	return g.members
+}
+
+func (g *ChannelGroup) MemberNames() []string {
+	names := []string{}
+	for _, ch := range g.members {
+		names = append(names, ch.Name())
+	}
+	return names
+}
+
+// Enqueue enqueues a copy of the message on each member of the group.
+// The newMessage builds a message container of the member's type for every member,
+// so a failure of one member doesn't affect the others.
+func (g *ChannelGroup) Enqueue(newMessage func(ch MessageChannelInterface) (interface{}, error)) []*GroupMemberResult {
+	return EnqueueToChannels(g.members, newMessage)
+}
+
+// EnqueueToChannels enqueues a copy of the message on each of the channels.
+func EnqueueToChannels(channels []MessageChannelInterface, newMessage func(ch MessageChannelInterface) (interface{}, error)) []*GroupMemberResult {
+	results := []*GroupMemberResult{}
+	for _, ch := range channels {
+		result := &GroupMemberResult{
+			Channel: ch.Name(),
+		}
+		results = append(results, result)
+
+		message, err := newMessage(ch)
+		if err != nil {
+			result.Err = err
+			continue
+		}
+		result.ID, result.Err = ch.Enqueue(message)
+		if bp, ok := ch.(BackpressureInterface); ok && eris.Is(result.Err, ErrChannelIsFull) {
+			result.RetryAfter = bp.RetryAfter()
+		}
+	}
+	return results
+}
@@ -0,0 +1,156 @@
+package channels
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rotisserie/eris"
+	"github.com/rs/zerolog"
+)
+
+var ErrMatrixInvalidOptions = eris.New("Invalid matrix channel options")
+
+var _matrixProviderOptions = []string{
+	"timeout",
+	"room",
+}
+
+const (
+	matrixSendPath     = "/_matrix/client/v3/rooms/{roomId}/send/m.room.message/{txnId}"
+	matrixLimitErrCode = "M_LIMIT_EXCEEDED"
+)
+
+// NewMatrixChannel creates a channel which sends the messages to a Matrix room:
+// matrix://access_token@matrix.example.com/channel_name?room=!room_id:example.com.
+func NewMatrixChannel(chanURL *url.URL, logger *zerolog.Logger) (MessageChannelInterface, error) {
+	token := chanURL.User.Username()
+	if token == "" {
+		return nil, eris.Wrap(ErrMatrixInvalidOptions, "access token is required")
+	}
+
+	provider, err := NewMatrixRoom("https://"+chanURL.Host, token, channelOptions(chanURL, _matrixProviderOptions), logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return newQueuedChannel(
+		chanURL, provider,
+		channelOptions(chanURL, _matrixProviderOptions, _queuedChannelOptions),
+		logger,
+	)
+}
+
+// matrixRoom sends the messages as m.room.message events.
+// The event IDs are strings, so the sent messages can't be edited or deleted.
+type matrixRoom struct {
+	httpClient *resty.Client
+	room       string
+	logger     *zerolog.Logger
+}
+
+func NewMatrixRoom(baseURL string, token string, opts map[string]string, logger *zerolog.Logger) (*matrixRoom, error) {
+	if opts["room"] == "" {
+		return nil, eris.Wrap(ErrMatrixInvalidOptions, "room is required")
+	}
+
+	timeout := httpTimeout
+	if val, ok := opts["timeout"]; ok {
+		var err error
+		if timeout, err = ParseDurationOption("timeout", val); err != nil {
+			return nil, err
+		}
+	}
+
+	httpClient := resty.New().
+		SetBaseURL(baseURL).
+		SetAuthToken(token).
+		SetTimeout(timeout).
+		SetRedirectPolicy(resty.NoRedirectPolicy()).
+		SetRetryCount(httpRetries).
+		SetRetryMaxWaitTime(httpMaxWaitTime).
+		SetRetryAfter(matrixRetryAfter).
+		AddRetryCondition(
+			func(r *resty.Response, err error) bool {
+				return r.StatusCode() == http.StatusTooManyRequests || r.StatusCode() >= http.StatusInternalServerError
+			},
+		)
+
+	provider := &matrixRoom{
+		httpClient: httpClient,
+		room:       opts["room"],
+		logger:     logger,
+	}
+	return provider, nil
+}
+
+func (mr *matrixRoom) HTTPClient() *http.Client {
+	return mr.httpClient.GetClient()
+}
+
+// ValidateMessage rejects the fields which can't be sent as the text events.
+func (mr *matrixRoom) ValidateMessage(message *TelegramMessage) error {
+	return validateTextMessage(message, "matrix")
+}
+
+// SendMessage sends the message event with a new transaction ID.
+func (mr *matrixRoom) SendMessage(message *TelegramMessage) ([]int, error) {
+	return mr.SendMessageWithID(newMessageID(), message)
+}
+
+// SendMessageWithID sends the message event with the proxy message ID as the transaction ID.
+// The ID is the same for all attempts to send the message, so the homeserver doesn't duplicate the event.
+func (mr *matrixRoom) SendMessageWithID(id string, message *TelegramMessage) ([]int, error) {
+	nodes := parseRichText(message.Text, message.ParseMode)
+
+	// The silent messages are sent as notices, clients don't notify about them.
+	content := map[string]interface{}{
+		"msgtype": "m.text",
+		"body":    richPlainText(nodes),
+	}
+	if message.DisableNotifications != 0 {
+		content["msgtype"] = "m.notice"
+	}
+	if message.ParseMode != nil {
+		content["format"] = "org.matrix.custom.html"
+		content["formatted_body"] = richHTML(nodes)
+	}
+
+	res, err := mr.httpClient.R().
+		SetPathParams(map[string]string{
+			"roomId": mr.room,
+			"txnId":  id,
+		}).
+		SetHeader("Content-Type", "application/json").
+		SetBody(content).
+		Put(matrixSendPath)
+	if err != nil {
+		return nil, eris.Wrap(err, "Error on send message")
+	}
+	if res.IsError() {
+		return nil, eris.New(
+			fmt.Sprintf(
+				"Failed request to Matrix homeserver: status: %d, body: %s",
+				res.StatusCode(), res.Body(),
+			),
+		)
+	}
+
+	return nil, nil
+}
+
+// matrixRetryAfter waits for the time from the "retry_after_ms" field of the M_LIMIT_EXCEEDED error
+// or from the Retry-After header.
+func matrixRetryAfter(c *resty.Client, r *resty.Response) (time.Duration, error) {
+	response := struct {
+		ErrCode      string `json:"errcode"`
+		RetryAfterMs int64  `json:"retry_after_ms"` //nolint:tagliatelle
+	}{}
+	if err := json.Unmarshal(r.Body(), &response); err == nil && response.ErrCode == matrixLimitErrCode && response.RetryAfterMs > 0 {
+		return time.Duration(response.RetryAfterMs) * time.Millisecond, nil
+	}
+	return retryAfterHeader(c, r)
+}
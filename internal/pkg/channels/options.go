@@ -0,0 +1,49 @@
+package channels
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rotisserie/eris"
+)
+
+var ErrInvalidChannelOption = eris.New("Invalid channel option")
+
+// housekeepingInterval is a period of checking the time windows of batching and deduplication.
+const housekeepingInterval = 100 * time.Millisecond
+
+// ParseDurationOption parses a duration in seconds ("30") or in the Go format ("1m30s").
+func ParseDurationOption(name string, val string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(val, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, eris.Wrapf(ErrInvalidChannelOption, "%s: %s", name, val)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		return 0, eris.Wrapf(ErrInvalidChannelOption, "%s: %s", name, val)
+	}
+	return d, nil
+}
+
+func parsePositiveIntOption(name string, val string) (int, error) {
+	res, err := strconv.Atoi(val)
+	if err != nil || res <= 0 {
+		return 0, eris.Wrapf(ErrInvalidChannelOption, "%s: %s", name, val)
+	}
+	return res, nil
+}
+
+// ParseAPIURLOption checks the base URL of an API: http or https URL without the query.
+// The trailing slash is removed.
+func ParseAPIURLOption(name string, val string) (string, error) {
+	u, err := url.Parse(val)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", eris.Wrapf(ErrInvalidChannelOption, "%s: %s", name, val)
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
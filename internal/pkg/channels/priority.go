@@ -0,0 +1,43 @@
+package channels
+
+import (
+	"github.com/rotisserie/eris"
+)
+
+// Priorities of messages. A message without the priority has the normal priority.
+const (
+	PriorityLow      = "low"
+	PriorityNormal   = "normal"
+	PriorityHigh     = "high"
+	PriorityCritical = "critical"
+)
+
+const (
+	priorityLevelLow = iota
+	priorityLevelNormal
+	priorityLevelHigh
+	priorityLevelCritical
+	priorityLevelsCount
+)
+
+var _priorityLevels = map[string]int{
+	PriorityLow:      priorityLevelLow,
+	"":               priorityLevelNormal,
+	PriorityNormal:   priorityLevelNormal,
+	PriorityHigh:     priorityLevelHigh,
+	PriorityCritical: priorityLevelCritical,
+}
+
+func validatePriority(priority string) error {
+	if _, ok := _priorityLevels[priority]; !ok {
+		return eris.Wrapf(ErrInvalidMessage, "unknown priority %q", priority)
+	}
+	return nil
+}
+
+func priorityLevel(priority string) int {
+	if level, ok := _priorityLevels[priority]; ok {
+		return level
+	}
+	return priorityLevelNormal
+}
@@ -0,0 +1,544 @@
+package channels
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rotisserie/eris"
+)
+
+// TelegramMessageQueueReserveRatio sets the share of the queue capacity reserved for the high priority messages:
+// 1/10 of the capacity is available to the high and critical messages on top of it.
+var TelegramMessageQueueReserveRatio = 10
+
+// Overflow policies of the full queue.
+const (
+	overflowReject     = "reject"
+	overflowBlock      = "block"
+	overflowDropOldest = "drop_oldest"
+	overflowSpill      = "spill"
+)
+
+var _overflowPolicies = map[string]bool{
+	overflowReject:     true,
+	overflowBlock:      true,
+	overflowDropOldest: true,
+	overflowSpill:      true,
+}
+
+const (
+	defaultOverflowTimeout = 1 * time.Second
+	drainRateSamples       = 100
+	spillRefillRatio       = 2
+	unknownRetryAfter      = 1 * time.Minute
+	maxRetryAfter          = 1 * time.Hour
+	spillFileMode          = 0o600
+)
+
+// messageQueue is a bounded priority queue of the channel tasks.
+// Tasks are taken by priority and in the FIFO order within the priority.
+// When the queue is full a new task sheds the oldest task with a lower priority,
+// otherwise the overflow policy of the channel is applied.
+type messageQueue struct {
+	mu       sync.Mutex
+	capacity int
+	reserve  int
+	size     int
+	levels   [priorityLevelsCount]*list.List
+	ready    chan struct{}
+
+	overflow string
+	timeout  time.Duration
+	spill    *queueSpill
+	freed    chan struct{}
+
+	// drained are the intervals between the pops of the busy queue, the idle time isn't counted.
+	drained []time.Duration
+	lastPop time.Time
+	busy    bool
+}
+
+// newMessageQueue creates the queue with the options queue_size, overflow, overflow_timeout and spill_path.
+func newMessageQueue(opts map[string]string) (*messageQueue, error) {
+	q := &messageQueue{
+		capacity: TelegramMessageQueueCap,
+		ready:    make(chan struct{}, 1),
+		overflow: overflowReject,
+		timeout:  defaultOverflowTimeout,
+		freed:    make(chan struct{}),
+	}
+	for i := range q.levels {
+		q.levels[i] = list.New()
+	}
+
+	var err error
+	if val, ok := opts["queue_size"]; ok {
+		if q.capacity, err = parsePositiveIntOption("queue_size", val); err != nil {
+			return nil, err
+		}
+	}
+	q.reserve = max(q.capacity/TelegramMessageQueueReserveRatio, 1)
+
+	if val, ok := opts["overflow"]; ok {
+		if !_overflowPolicies[val] {
+			return nil, eris.Wrapf(ErrInvalidChannelOption, "overflow: %s", val)
+		}
+		q.overflow = val
+	}
+	if val, ok := opts["overflow_timeout"]; ok {
+		if q.timeout, err = ParseDurationOption("overflow_timeout", val); err != nil {
+			return nil, err
+		}
+	}
+
+	path, ok := opts["spill_path"]
+	switch {
+	case q.overflow == overflowSpill && !ok:
+		return nil, eris.Wrap(ErrInvalidChannelOption, "overflow=spill requires spill_path")
+	case q.overflow == overflowSpill:
+		if q.spill, err = newQueueSpill(path); err != nil {
+			return nil, err
+		}
+	case ok:
+		return nil, eris.Wrap(ErrInvalidChannelOption, "spill_path requires overflow=spill")
+	}
+
+	// The tasks spilled before the restart are sent first.
+	if err := q.refill(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// push adds the task to the queue and returns the task shed to make room for it.
+// The spilled task is written to the file out of the queue lock, so the disk doesn't hold up the worker and the other pushes.
+func (q *messageQueue) push(task *messageTask) (*messageTask, error) {
+	shed, spill, err := q.admit(task)
+	if err != nil || !spill {
+		return shed, err
+	}
+
+	if err := q.spill.push(task); err != nil {
+		return nil, err
+	}
+	// The worker could find the spill empty while the task was written, it refills the queue on the wake up.
+	q.notify()
+	return nil, nil
+}
+
+// admit puts the task to the queue or reserves the place for it in the spill file.
+func (q *messageQueue) admit(task *messageTask) (*messageTask, bool, error) {
+	deadline := time.Now().Add(q.timeout)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var shed *messageTask
+	for {
+		limit := q.capacity
+		if task.priority >= priorityLevelHigh {
+			limit += q.reserve
+		}
+
+		// The spilled tasks go first to keep the FIFO order, only the high priority tasks may overtake them.
+		if q.spill != nil && (q.size >= limit || q.spill.len() > 0 && task.priority < priorityLevelHigh) {
+			return nil, true, q.spill.reserve(task)
+		}
+		if q.size < limit {
+			break
+		}
+		if shed = q.shedLocked(task.priority); shed != nil {
+			break
+		}
+		if q.overflow == overflowDropOldest {
+			if shed = q.shedLocked(task.priority + 1); shed != nil {
+				break
+			}
+		}
+
+		wait := time.Until(deadline)
+		if q.overflow != overflowBlock || wait <= 0 {
+			return nil, false, ErrChannelIsFull
+		}
+
+		freed := q.freed
+		q.mu.Unlock()
+		select {
+		case <-freed:
+		case <-time.After(wait):
+		}
+		q.mu.Lock()
+	}
+
+	q.pushLocked(task)
+	return shed, false, nil
+}
+
+func (q *messageQueue) pushLocked(task *messageTask) {
+	q.levels[task.priority].PushBack(task)
+	q.size++
+	q.notify()
+}
+
+// shedLocked removes the oldest task with the lowest priority below the given one.
+func (q *messageQueue) shedLocked(priority int) *messageTask {
+	for level := priorityLevelLow; level < priority; level++ {
+		if el := q.levels[level].Front(); el != nil {
+			q.size--
+			return q.levels[level].Remove(el).(*messageTask) //nolint:forcetypeassert
+		}
+	}
+	return nil
+}
+
+// pop takes the oldest task with the highest priority.
+func (q *messageQueue) pop() *messageTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var task *messageTask
+	for level := priorityLevelsCount - 1; level >= priorityLevelLow; level-- {
+		if el := q.levels[level].Front(); el != nil {
+			task = q.levels[level].Remove(el).(*messageTask) //nolint:forcetypeassert
+			q.size--
+			break
+		}
+	}
+	if task == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if q.busy {
+		q.drained = append(q.drained, now.Sub(q.lastPop))
+		if len(q.drained) > drainRateSamples {
+			q.drained = q.drained[1:]
+		}
+	}
+	q.lastPop = now
+	q.busy = q.size > 0 || q.spill != nil && q.spill.len() > 0
+	close(q.freed)
+	q.freed = make(chan struct{})
+
+	if q.size > 0 {
+		q.notify()
+	}
+	return task
+}
+
+// refill moves the spilled tasks back to the queue when it is drained below the half of the capacity.
+// The tasks are taken in batches, so the spill file is read once for many sent tasks.
+func (q *messageQueue) refill() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.spill == nil || q.spill.len() == 0 || q.size > q.capacity/spillRefillRatio {
+		return nil
+	}
+
+	tasks, err := q.spill.take(q.capacity - q.size)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		q.pushLocked(task)
+	}
+	return nil
+}
+
+func (q *messageQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.size
+}
+
+func (q *messageQueue) spilled() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.spill == nil {
+		return 0
+	}
+	return q.spill.len()
+}
+
+// retryAfter estimates the time to drain the queue and the spill file at the recent drain rate.
+func (q *messageQueue) retryAfter() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.drained) == 0 {
+		return unknownRetryAfter
+	}
+
+	var elapsed time.Duration
+	for _, d := range q.drained {
+		elapsed += d
+	}
+	perTask := max(elapsed, time.Millisecond) / time.Duration(len(q.drained))
+	pending := q.size
+	if q.spill != nil {
+		pending += q.spill.len()
+	}
+	wait := time.Duration(pending) * perTask
+
+	return min(max(wait, time.Second), maxRetryAfter)
+}
+
+// notify wakes up the worker waiting on the ready channel.
+func (q *messageQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+type spilledTask struct {
+	Operation messageOperation `json:"operation"`
+	ID        string           `json:"id"`
+	Priority  int              `json:"priority"`
+	ExpiresAt time.Time        `json:"expiresAt"`
+	Message   *TelegramMessage `json:"message,omitempty"`
+}
+
+// queueSpill keeps the overflowing tasks in a JSON Lines file until the queue has room.
+// The tasks are appended to the file and read from the saved offset, the file is compacted
+// when the most of it is read. The file survives restarts, so the spilled tasks are sent after the restart of the program.
+// The place of a task is reserved under the queue lock and the task is written after it, the reserved tasks are counted
+// as spilled to keep the FIFO order of the pushes.
+type queueSpill struct {
+	mu       sync.Mutex
+	path     string
+	count    int
+	reserved int
+	offset   int64
+}
+
+func newQueueSpill(path string) (*queueSpill, error) {
+	s := &queueSpill{path: path}
+
+	data, err := os.ReadFile(s.offsetPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, eris.Wrapf(err, "Failed to read spill offset %s", s.offsetPath())
+	}
+	if len(data) > 0 {
+		if s.offset, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err != nil || s.offset < 0 {
+			return nil, eris.Wrapf(ErrInvalidChannelOption, "invalid spill offset in %s", s.offsetPath())
+		}
+	}
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.offset = 0
+		return s, nil
+	}
+	if err != nil {
+		return nil, eris.Wrapf(err, "Failed to open spill file %s", s.path)
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil || s.offset > info.Size() {
+		s.offset = 0
+	}
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return nil, eris.Wrapf(err, "Failed to read spill file %s", s.path)
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			s.count++
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, eris.Wrapf(err, "Failed to read spill file %s", s.path)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *queueSpill) offsetPath() string {
+	return s.path + ".offset"
+}
+
+func (s *queueSpill) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.count + s.reserved
+}
+
+// reserve counts the task as spilled before it is written by push.
+func (s *queueSpill) reserve(task *messageTask) error {
+	if task.message != nil && len(task.message.files) > 0 {
+		return eris.Wrap(ErrChannelIsFull, "messages with uploaded files can't be spilled to disk")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reserved++
+	return nil
+}
+
+// push appends the reserved task to the file.
+func (s *queueSpill) push(task *messageTask) error {
+	line, err := json.Marshal(&spilledTask{
+		Operation: task.operation,
+		ID:        task.id,
+		Priority:  task.priority,
+		ExpiresAt: task.expiresAt,
+		Message:   task.message,
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reserved--
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, spillFileMode)
+	if err != nil {
+		return eris.Wrapf(err, "Failed to open spill file %s", s.path)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return eris.Wrapf(err, "Failed to write spill file %s", s.path)
+	}
+	s.count++
+
+	return nil
+}
+
+// take reads up to n oldest tasks from the offset and moves the offset after them.
+func (s *queueSpill) take(n int) ([]*messageTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n <= 0 || s.count == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, eris.Wrapf(err, "Failed to open spill file %s", s.path)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return nil, eris.Wrapf(err, "Failed to read spill file %s", s.path)
+	}
+
+	tasks := make([]*messageTask, 0, min(n, s.count))
+	offset := s.offset
+	r := bufio.NewReader(f)
+	for len(tasks) < n {
+		line, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, eris.Wrapf(err, "Failed to read spill file %s", s.path)
+		}
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) > 0 {
+			st := &spilledTask{}
+			if err := json.Unmarshal(line, st); err != nil {
+				return nil, eris.Wrapf(err, "Failed to parse spill file %s", s.path)
+			}
+			tasks = append(tasks, &messageTask{
+				operation: st.Operation,
+				id:        st.ID,
+				priority:  min(max(st.Priority, priorityLevelLow), priorityLevelsCount-1),
+				expiresAt: st.ExpiresAt,
+				message:   st.Message,
+			})
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	s.count = max(s.count-len(tasks), 0)
+	if err := s.commit(f, offset); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// commit saves the offset of the taken tasks. The file is removed when all tasks are taken
+// and compacted when the taken tasks fill the most of it.
+func (s *queueSpill) commit(f *os.File, offset int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return eris.Wrapf(err, "Failed to read spill file %s", s.path)
+	}
+
+	switch {
+	case s.count == 0 || offset >= info.Size():
+		s.count = 0
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return eris.Wrapf(err, "Failed to remove spill file %s", s.path)
+		}
+		return s.saveOffset(0)
+	case offset*spillRefillRatio >= info.Size():
+		return s.compact(f, offset)
+	}
+	return s.saveOffset(offset)
+}
+
+// compact rewrites the file without the taken tasks. The offset is reset before the file is replaced,
+// so the crash in between sends the taken tasks again instead of losing the rest.
+func (s *queueSpill) compact(f *os.File, offset int64) error {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return eris.Wrapf(err, "Failed to read spill file %s", s.path)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return eris.Wrapf(err, "Failed to write spill file %s", s.path)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, f); err != nil {
+		tmp.Close()
+		return eris.Wrapf(err, "Failed to write spill file %s", s.path)
+	}
+	if err := tmp.Close(); err != nil {
+		return eris.Wrapf(err, "Failed to write spill file %s", s.path)
+	}
+
+	if err := s.saveOffset(0); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *queueSpill) saveOffset(offset int64) error {
+	s.offset = offset
+	if offset == 0 {
+		if err := os.Remove(s.offsetPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return eris.Wrapf(err, "Failed to remove spill offset %s", s.offsetPath())
+		}
+		return nil
+	}
+	if err := os.WriteFile(s.offsetPath(), []byte(strconv.FormatInt(offset, 10)), spillFileMode); err != nil {
+		return eris.Wrapf(err, "Failed to write spill offset %s", s.offsetPath())
+	}
+	return nil
+}
@@ -0,0 +1,808 @@
+package channels
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rotisserie/eris"
+	"github.com/rs/zerolog"
+
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/storage"
+)
+
+var _queuedChannelOptions = []string{
+	"state",
+	"batch_window",
+	"batch_size",
+	"batch_max_length",
+	"dedup_window",
+	"dedup_size",
+	"dedup_mode",
+	"quiet_hours",
+	"quiet_tz",
+	"quiet_holidays",
+	"quiet_mode",
+	"ttl",
+	"dead_letters",
+	"queue_size",
+	"overflow",
+	"overflow_timeout",
+	"spill_path",
+	"fallback",
+	"circuit_failures",
+	"circuit_timeout",
+}
+
+// messageProviderInterface delivers the messages of the queued channel to the service.
+// The IDs of the sent messages are used to edit and delete them, if the provider supports editing.
+type messageProviderInterface interface {
+	SendMessage(*TelegramMessage) ([]int, error)
+	HTTPClient() *http.Client
+}
+
+// messageEditorProviderInterface is implemented by the providers which can edit and delete the sent messages.
+type messageEditorProviderInterface interface {
+	EditMessage(messageIDs []int, message *TelegramMessage) error
+	DeleteMessage(messageIDs []int) error
+}
+
+// idempotentProviderInterface is implemented by the providers which deduplicate the messages by the ID,
+// the ID of the proxy task is the same for all attempts to send the message.
+type idempotentProviderInterface interface {
+	SendMessageWithID(id string, message *TelegramMessage) ([]int, error)
+}
+
+// messageValidatorProviderInterface is implemented by the providers which support only a part of the message fields.
+type messageValidatorProviderInterface interface {
+	ValidateMessage(message *TelegramMessage) error
+}
+
+type messageOperation int
+
+const (
+	sendOperation messageOperation = iota
+	editOperation
+	deleteOperation
+)
+
+// messageTask is an operation on a message processed by the channel worker.
+// Sends, edits and deletes share the queue, so the edits are applied in the order of the sends.
+// Edits and deletes inherit the priority of the sent message.
+type messageTask struct {
+	operation messageOperation
+	id        string
+	priority  int
+	expiresAt time.Time
+	message   *TelegramMessage
+}
+
+type queuedChannel struct {
+	chanURL *url.URL
+
+	logger    *zerolog.Logger
+	name      string
+	queue     *messageQueue
+	provider  messageProviderInterface
+	editor    messageEditorProviderInterface
+	options   map[string]string
+	registry  *messageRegistry
+	state     storage.Storage
+	topics    *telegramTopics
+	batcher   *messageBatcher
+	dedup     *messageDeduplicator
+	scheduler *messageScheduler
+	quiet     *quietHours
+	ttl       time.Duration
+
+	stats       channelStats
+	deadLetters *deadLetterStore
+	fallbacks   []*queuedChannel
+	circuit     *circuitBreaker
+	acks        *messageAcks
+
+	mu            sync.Mutex
+	doneProcesors chan bool
+}
+
+// channelOptions collects the options known to the channel type from the query of the channel URL.
+func channelOptions(chanURL *url.URL, known ...[]string) map[string]string {
+	options := map[string]string{}
+	for _, names := range known {
+		for _, opt := range names {
+			if val := chanURL.Query().Get(opt); val != "" {
+				options[opt] = val
+			}
+		}
+	}
+	return options
+}
+
+// newQueuedChannel creates a channel which queues the messages and delivers them by the provider.
+func newQueuedChannel(chanURL *url.URL, provider messageProviderInterface, options map[string]string, logger *zerolog.Logger) (*queuedChannel, error) {
+	var err error
+	state := storage.NewMemoryStorage()
+	if path, ok := options["state"]; ok {
+		state, err = storage.NewFileStorage(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	creator, _ := provider.(telegramTopicCreator)
+	topics, err := newTelegramTopics(options, creator, state)
+	if err != nil {
+		return nil, err
+	}
+
+	batcher, err := newMessageBatcher(options)
+	if err != nil {
+		return nil, err
+	}
+
+	editor, _ := provider.(messageEditorProviderInterface)
+	dedup, err := newMessageDeduplicator(options)
+	if err != nil {
+		return nil, err
+	}
+	if dedup.mode == dedupModeEdit && editor == nil {
+		return nil, eris.Wrap(ErrInvalidChannelOption, "dedup_mode=edit: the channel can't edit messages")
+	}
+
+	quiet, err := newQuietHours(options)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := newMessageQueue(options)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters, err := newDeadLetterStore(options, state)
+	if err != nil {
+		return nil, err
+	}
+
+	circuit, err := newCircuitBreaker(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// The name option is used by the channel types which need the path of the URL.
+	name, ok := options["name"]
+	if !ok {
+		name = strings.Trim(chanURL.Path, "/")
+	}
+
+	_, isTelegram := provider.(*telegramChat)
+	acks, err := newMessageAcks(options, name, isTelegram, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := &queuedChannel{
+		chanURL:  chanURL,
+		logger:   logger,
+		name:     name,
+		provider: provider,
+		editor:   editor,
+		options:  options,
+		queue:    queue,
+		registry: newMessageRegistry(SentMessagesRegistryCap),
+		state:    state,
+		topics:   topics,
+		batcher:  batcher,
+		dedup:    dedup,
+		quiet:    quiet,
+
+		deadLetters: deadLetters,
+		circuit:     circuit,
+		acks:        acks,
+	}
+	if val, ok := options["ttl"]; ok {
+		if channel.ttl, err = ParseDurationOption("ttl", val); err != nil {
+			return nil, err
+		}
+	}
+
+	channel.scheduler, err = newMessageScheduler(state, channel.enqueueScheduled, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// The scheduled messages restored from the state are found by their IDs and keys again.
+	for _, sm := range channel.scheduler.list() {
+		channel.registry.add(sm.ID, sm.Message.Key, priorityLevel(sm.Message.Priority))
+		if acks.needButton(sm.Message) {
+			channel.registry.setAckMessage(sm.ID, sm.Message)
+		}
+	}
+
+	return channel, nil
+}
+
+func (ch *queuedChannel) Stop() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.doneProcesors != nil {
+		close(ch.doneProcesors)
+		ch.doneProcesors = nil
+	}
+	return nil
+}
+
+func (ch *queuedChannel) Start() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.doneProcesors != nil {
+		close(ch.doneProcesors)
+	}
+
+	done := make(chan bool, 1)
+	go ch.processQueue(done)
+	go ch.scheduler.run(done)
+	ch.doneProcesors = done
+
+	return nil
+}
+
+func (ch *queuedChannel) String() string {
+	opts := []string{}
+	for k, v := range ch.options {
+		opts = append(opts, k+"="+v)
+	}
+	sort.Strings(opts)
+
+	var sOpts string
+	if len(opts) > 0 {
+		sOpts = "?" + strings.Join(opts, "&")
+	}
+
+	// The user without a password is an access token, it is hidden completely.
+	var user string
+	if ch.chanURL.User != nil {
+		user = "***@"
+		if _, ok := ch.chanURL.User.Password(); ok {
+			user = ch.chanURL.User.Username() + ":***@"
+		}
+	}
+
+	return fmt.Sprintf(
+		"%s://%s%s%s%s",
+		ch.chanURL.Scheme, user, ch.chanURL.Host, ch.chanURL.Path, sOpts,
+	)
+}
+
+func (ch *queuedChannel) Name() string {
+	return ch.name
+}
+
+func (ch *queuedChannel) Provider() interface{ HTTPClient() *http.Client } {
+	return ch.provider
+}
+
+func (ch *queuedChannel) MessageContainer() interface{} {
+	return &TelegramMessage{}
+}
+
+func (ch *queuedChannel) Enqueue(newMessage interface{}) (string, error) {
+	message, ok := newMessage.(*TelegramMessage)
+	if !ok {
+		return "", ErrInvalidMessageType
+	}
+
+	if err := ch.acks.validate(message); err != nil {
+		return "", err
+	}
+	id := newMessageID()
+	ack := ch.acks.addButton(message, ch.name, id)
+	if err := ch.validate(message, message.Validate); err != nil {
+		return "", err
+	}
+	if err := ch.topics.validate(message); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	sendAt, err := message.scheduledAt(now)
+	if err != nil {
+		return "", err
+	}
+	expiresAt, err := message.expiresAt(now, ch.ttl)
+	if err != nil {
+		return "", err
+	}
+
+	task := &messageTask{
+		operation: sendOperation,
+		id:        id,
+		priority:  priorityLevel(message.Priority),
+		expiresAt: expiresAt,
+		message:   message,
+	}
+	ch.registry.add(task.id, message.Key, task.priority)
+	if ack {
+		ch.registry.setAckMessage(task.id, message)
+	}
+
+	if !sendAt.IsZero() {
+		if err := ch.scheduler.add(&ScheduledMessage{ID: task.id, SendAt: sendAt, Message: message}); err != nil {
+			ch.registry.remove(task.id)
+			return "", err
+		}
+		ch.logger.Info().Msgf("Schedule message %s at %s: %s", task.id, sendAt.Format(time.RFC3339), message)
+		return task.id, nil
+	}
+
+	if err := ch.enqueueTask(task); err != nil {
+		ch.registry.remove(task.id)
+		return "", err
+	}
+
+	ch.logger.Info().Msgf("Enqueue message %s: %s", task.id, message)
+	return task.id, nil
+}
+
+// enqueueScheduled hands a due scheduled message to the queue.
+func (ch *queuedChannel) enqueueScheduled(sm *ScheduledMessage) error {
+	var expiresAt time.Time
+	if sm.ExpiresAt != nil {
+		expiresAt = *sm.ExpiresAt
+	} else {
+		var err error
+		if expiresAt, err = sm.Message.expiresAt(time.Now(), ch.ttl); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := ch.registry.resolve(sm.ID); !ok {
+		ch.registry.add(sm.ID, sm.Message.Key, priorityLevel(sm.Message.Priority))
+	}
+
+	if err := ch.enqueueTask(&messageTask{
+		operation: sendOperation,
+		id:        sm.ID,
+		priority:  priorityLevel(sm.Message.Priority),
+		expiresAt: expiresAt,
+		message:   sm.Message,
+	}); err != nil {
+		return err
+	}
+
+	ch.logger.Info().Msgf("Enqueue scheduled message %s: %s", sm.ID, sm.Message)
+	return nil
+}
+
+func (ch *queuedChannel) Scheduled() []*ScheduledMessage {
+	return ch.scheduler.list()
+}
+
+func (ch *queuedChannel) CancelScheduled(id string) error {
+	if sm, ok := ch.registry.resolve(id); ok {
+		id = sm.id
+	}
+	if err := ch.scheduler.cancel(id); err != nil {
+		return err
+	}
+	ch.registry.remove(id)
+
+	ch.logger.Info().Msgf("Scheduled message %s is canceled", id)
+	return nil
+}
+
+func (ch *queuedChannel) EnqueueEdit(id string, newMessage interface{}) error {
+	message, ok := newMessage.(*TelegramMessage)
+	if !ok {
+		return ErrInvalidMessageType
+	}
+	if ch.editor == nil {
+		return eris.Wrap(ErrOperationNotSupported, "editing of messages")
+	}
+	if err := ch.validate(message, message.validateEdit); err != nil {
+		return err
+	}
+
+	sm, ok := ch.registry.resolve(id)
+	if !ok {
+		return eris.Wrap(ErrMessageNotFound, id)
+	}
+
+	if err := ch.enqueueTask(&messageTask{
+		operation: editOperation,
+		id:        sm.id,
+		priority:  sm.priority,
+		message:   message,
+	}); err != nil {
+		return err
+	}
+
+	ch.logger.Info().Msgf("Enqueue edit of message %s: %s", sm.id, message)
+	return nil
+}
+
+func (ch *queuedChannel) EnqueueDelete(id string) error {
+	if ch.editor == nil {
+		return eris.Wrap(ErrOperationNotSupported, "deleting of messages")
+	}
+
+	sm, ok := ch.registry.resolve(id)
+	if !ok {
+		return eris.Wrap(ErrMessageNotFound, id)
+	}
+
+	if err := ch.enqueueTask(&messageTask{
+		operation: deleteOperation,
+		id:        sm.id,
+		priority:  sm.priority,
+	}); err != nil {
+		return err
+	}
+
+	ch.logger.Info().Msgf("Enqueue delete of message %s", sm.id)
+	return nil
+}
+
+// validate checks the message and the fields supported by the provider.
+func (ch *queuedChannel) validate(message *TelegramMessage, validate func() error) error {
+	if err := validate(); err != nil {
+		return err
+	}
+	if validator, ok := ch.provider.(messageValidatorProviderInterface); ok {
+		return validator.ValidateMessage(message)
+	}
+	return nil
+}
+
+func (ch *queuedChannel) enqueueTask(task *messageTask) error {
+	shed, err := ch.queue.push(task)
+	if err != nil {
+		return err
+	}
+	if task.operation == sendOperation {
+		ch.stats.enqueued.Add(1)
+	}
+
+	if shed != nil {
+		ch.stats.shed.Add(1)
+		ch.logger.Warn().Msgf("Queue is full, task on message %s is shed in favor of message %s", shed.id, task.id)
+		if shed.operation == sendOperation {
+			ch.registry.remove(shed.id)
+			ch.addDeadLetter(shed, DeadLetterShed)
+		}
+	}
+	return nil
+}
+
+// Stats returns the counters of the channel.
+func (ch *queuedChannel) Stats() *ChannelStats {
+	stats := ch.stats.snapshot()
+	stats.Queued = ch.queue.len()
+	stats.Spilled = ch.queue.spilled()
+	stats.Scheduled = len(ch.scheduler.list())
+	return stats
+}
+
+// RetryAfter estimates when the full queue will have room.
+func (ch *queuedChannel) RetryAfter() time.Duration {
+	return ch.queue.retryAfter()
+}
+
+func (ch *queuedChannel) DeadLetters() ([]*DeadLetter, error) {
+	return ch.deadLetters.list()
+}
+
+// MessageStatus returns the delivery status of the message by the ID or by the client correlation key.
+func (ch *queuedChannel) MessageStatus(idOrKey string) (*MessageStatus, error) {
+	sm, ok := ch.registry.resolve(idOrKey)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	status := &MessageStatus{
+		ID:             sm.id,
+		Key:            sm.key,
+		Status:         sm.status,
+		DeliveredBy:    sm.deliveredBy,
+		AcknowledgedBy: sm.ackedBy,
+	}
+	if !sm.ackedAt.IsZero() {
+		status.AcknowledgedAt = &sm.ackedAt
+	}
+	return status, nil
+}
+
+// SentBy reports whether the channel sends the messages by the Telegram bot with the token.
+func (ch *queuedChannel) SentBy(botToken string) bool {
+	chat, ok := ch.provider.(*telegramChat)
+	return ok && chat.botToken == botToken
+}
+
+// Acknowledge handles the press of the Acknowledge button: the message is edited to show who and when acknowledged it,
+// then the ack hook is called.
+func (ch *queuedChannel) Acknowledge(idOrKey string, by string, at time.Time) error {
+	sm, first := ch.registry.acknowledge(idOrKey, by, at)
+	if sm == nil {
+		return eris.Wrap(ErrMessageNotFound, idOrKey)
+	}
+	if !first {
+		return eris.Wrapf(ErrAlreadyAcknowledged, "by %s", sm.ackedBy)
+	}
+	ch.logger.Info().Msgf("Message %s is acknowledged by %s", sm.id, by)
+
+	event := &AckEvent{
+		Channel: ch.name,
+		ID:      sm.id,
+		Key:     sm.key,
+		By:      by,
+		At:      at,
+	}
+	if sm.ackMessage != nil {
+		event.Text = sm.ackMessage.Text
+		if err := ch.EnqueueEdit(sm.id, ch.acks.acknowledged(sm.ackMessage, by, at)); err != nil {
+			ch.logger.Error().Msgf("Failed to edit acknowledged message %s: %s", sm.id, err)
+		}
+	}
+	ch.acks.callHook(event)
+
+	return nil
+}
+
+func (ch *queuedChannel) addDeadLetter(task *messageTask, reason string) {
+	if err := ch.deadLetters.add(task.id, reason, task.message); err != nil {
+		ch.logger.Error().Msgf("Failed to save message %s to the dead letters: %s", task.id, err)
+	}
+}
+
+// dropExpired drops the message if its delivery deadline has passed.
+func (ch *queuedChannel) dropExpired(task *messageTask, now time.Time) bool {
+	if task.operation != sendOperation || task.expiresAt.IsZero() || now.Before(task.expiresAt) {
+		return false
+	}
+
+	ch.stats.expired.Add(1)
+	ch.registry.remove(task.id)
+	ch.addDeadLetter(task, DeadLetterExpired)
+	ch.logger.Warn().Msgf("Message %s expired at %s and is dropped: %s", task.id, task.expiresAt.Format(time.RFC3339), task.message)
+	return true
+}
+
+func (ch *queuedChannel) processQueue(done chan bool) {
+	var ticks <-chan time.Time
+	if ch.batcher.enabled() || ch.dedup.enabled() {
+		ticker := time.NewTicker(housekeepingInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-done:
+			// The buffered messages aren't kept anywhere, the digest is sent before the stop.
+			if tasks := ch.batcher.take(); len(tasks) > 0 {
+				ch.sendDigest(tasks)
+			}
+			return
+		case <-ch.queue.ready:
+			task := ch.queue.pop()
+			if err := ch.queue.refill(); err != nil {
+				ch.logger.Error().Msgf("Failed to refill queue from spill file: %s", err)
+			}
+			if task == nil {
+				continue
+			}
+
+			if ch.dropExpired(task, time.Now()) {
+				continue
+			}
+			if held := ch.applyQuietHours(task); held {
+				continue
+			}
+
+			suppressed, original, evicted := ch.dedup.suppress(task, time.Now())
+			for _, entry := range evicted {
+				ch.reportRepeats(entry)
+			}
+			if suppressed {
+				ch.logger.Info().Msgf("Message %s suppressed as a repeat of message %s: %s", task.id, original.task.id, task.message)
+				continue
+			}
+
+			if ch.batcher.accepts(task) {
+				if full := ch.batcher.add(task); full {
+					ch.sendDigest(ch.batcher.take())
+				}
+				continue
+			}
+			ch.processTask(task)
+		case now := <-ticks:
+			if tasks := ch.batcher.due(now); len(tasks) > 0 {
+				ch.sendDigest(tasks)
+			}
+			for _, entry := range ch.dedup.expired(now) {
+				ch.reportRepeats(entry)
+			}
+		}
+	}
+}
+
+// applyQuietHours disables notifications for the messages sent in the quiet hours
+// or holds them until the end of the quiet period. Critical messages are sent as is.
+func (ch *queuedChannel) applyQuietHours(task *messageTask) bool {
+	if !ch.quiet.enabled() || task.operation != sendOperation || task.message.Priority == PriorityCritical {
+		return false
+	}
+
+	until, ok := ch.quiet.until(time.Now())
+	if !ok {
+		return false
+	}
+
+	if ch.quiet.hold {
+		sm := &ScheduledMessage{ID: task.id, SendAt: until, Message: task.message}
+		if !task.expiresAt.IsZero() {
+			sm.ExpiresAt = &task.expiresAt
+		}
+		err := ch.scheduler.add(sm)
+		if err == nil {
+			ch.logger.Info().Msgf("Message %s is held by the quiet hours until %s", task.id, until.Format(time.RFC3339))
+			return true
+		}
+		ch.logger.Error().Msgf("Failed to hold message %s by the quiet hours, it will be sent silently: %s", task.id, err)
+	}
+
+	task.message.DisableNotifications = 1
+	return false
+}
+
+func (ch *queuedChannel) processTask(task *messageTask) {
+	switch task.operation {
+	case sendOperation:
+		target, err := ch.sendWithFallbacks(task)
+		if err != nil {
+			ch.stats.failed.Add(1)
+			ch.logger.Error().Msgf("Failed to send message %s: %s", task.id, err)
+			return
+		}
+		ch.stats.sent.Add(1)
+		if target != ch.name {
+			ch.logger.Info().Msgf("Message %s successful sended by the fallback channel %s: %s", task.id, target, task.message)
+			return
+		}
+		ch.logger.Info().Msgf("Message %s successful sended: %s", task.id, task.message)
+
+	case editOperation:
+		if err := ch.editTask(task); err != nil {
+			ch.logger.Error().Msgf("Failed to edit message %s: %s", task.id, err)
+			return
+		}
+		ch.logger.Info().Msgf("Message %s successful edited: %s", task.id, task.message)
+
+	case deleteOperation:
+		if err := ch.deleteTask(task); err != nil {
+			ch.logger.Error().Msgf("Failed to delete message %s: %s", task.id, err)
+			return
+		}
+		ch.logger.Info().Msgf("Message %s successful deleted", task.id)
+	}
+}
+
+func (ch *queuedChannel) sendTask(task *messageTask) error {
+	if err := ch.topics.resolve(task.message); err != nil {
+		return err
+	}
+
+	// The parts sent before the failure are kept, so they can be edited or deleted.
+	ids, err := ch.sendMessage(task.id, task.message)
+	if len(ids) > 0 {
+		ch.registry.setProviderIDs(task.id, ids)
+	}
+	return err
+}
+
+func (ch *queuedChannel) sendMessage(id string, message *TelegramMessage) ([]int, error) {
+	if provider, ok := ch.provider.(idempotentProviderInterface); ok {
+		return provider.SendMessageWithID(id, message)
+	}
+	return ch.provider.SendMessage(message)
+}
+
+func (ch *queuedChannel) editTask(task *messageTask) error {
+	sm, ok := ch.registry.resolve(task.id)
+	if !ok || len(sm.providerIDs) == 0 {
+		return ErrMessageWasNotSent
+	}
+
+	return ch.editor.EditMessage(sm.providerIDs, task.message)
+}
+
+func (ch *queuedChannel) deleteTask(task *messageTask) error {
+	sm, ok := ch.registry.resolve(task.id)
+	if !ok || len(sm.providerIDs) == 0 {
+		return ErrMessageWasNotSent
+	}
+
+	if err := ch.editor.DeleteMessage(sm.providerIDs); err != nil {
+		return err
+	}
+	ch.registry.remove(task.id)
+
+	return nil
+}
+
+// sendDigest sends the batched messages as one digest message.
+// Every batched message is logged with the digest ID to keep the delivery log complete.
+func (ch *queuedChannel) sendDigest(tasks []*messageTask) {
+	if len(tasks) == 1 {
+		ch.processTask(tasks[0])
+		return
+	}
+
+	digest := &messageTask{
+		operation: sendOperation,
+		id:        newMessageID(),
+		message:   ch.batcher.digest(tasks),
+	}
+	target, err := ch.sendWithFallbacks(digest)
+	if err != nil {
+		ch.stats.failed.Add(uint64(len(tasks)))
+		for _, task := range tasks {
+			ch.registry.setFailed(task.id)
+			ch.logger.Error().Msgf("Failed to send message %s in digest %s: %s", task.id, digest.id, err)
+		}
+		return
+	}
+
+	ch.stats.sent.Add(uint64(len(tasks)))
+	for _, task := range tasks {
+		ch.registry.setDeliveredBy(task.id, target)
+		ch.logger.Info().Msgf("Message %s successful sended in digest %s by %s: %s", task.id, digest.id, target, task.message)
+	}
+}
+
+// reportRepeats tells the chat how many times the message was repeated during the suppression window:
+// by a follow-up reply to the original message or by editing the original message.
+func (ch *queuedChannel) reportRepeats(entry *dedupEntry) {
+	original := entry.task.message
+	sm, sent := ch.registry.resolve(entry.task.id)
+	sent = sent && len(sm.providerIDs) > 0
+
+	if ch.dedup.mode == dedupModeEdit && sent {
+		edited := *original
+		edited.Text += "\n\n" + repeatsNote(entry.repeats, original.ParseMode)
+		if err := ch.editor.EditMessage(sm.providerIDs, &edited); err != nil {
+			ch.logger.Error().Msgf("Failed to edit message %s with repeats count: %s", entry.task.id, err)
+			return
+		}
+		ch.logger.Info().Msgf("Message %s repeated %d times, the message is edited", entry.task.id, entry.repeats)
+		return
+	}
+
+	followup := &TelegramMessage{
+		Text:                 "Previous message repeated " + repeatsCount(entry.repeats),
+		DisableNotifications: 1,
+		MessageThreadID:      original.MessageThreadID,
+	}
+	if sent {
+		followup.ReplyToMessageID = &sm.providerIDs[0]
+	} else {
+		followup.Text += ": " + truncateText(original.Text, repeatsSnippetLength)
+	}
+
+	task := &messageTask{
+		operation: sendOperation,
+		id:        newMessageID(),
+		message:   followup,
+	}
+	if err := ch.sendTask(task); err != nil {
+		ch.logger.Error().Msgf("Failed to send repeats count of message %s: %s", entry.task.id, err)
+		return
+	}
+	ch.logger.Info().Msgf("Message %s repeated %d times, the follow-up %s is sent", entry.task.id, entry.repeats, task.id)
+}
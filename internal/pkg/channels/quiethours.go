@@ -0,0 +1,208 @@
+package channels
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rotisserie/eris"
+)
+
+const (
+	quietModeSilent = "silent"
+	quietModeHold   = "hold"
+
+	minutesInHour = 60
+	minutesInDay  = 24 * minutesInHour
+	daysInWeek    = 7
+
+	// Enough to join the adjacent windows and holidays of a long weekend.
+	quietPeriodMaxExtensions = 32
+)
+
+var _weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// quietWindow is a daily period of quiet hours. A window which ends before it starts spans midnight
+// and belongs to the day of its start.
+type quietWindow struct {
+	days  [daysInWeek]bool
+	start int
+	end   int
+}
+
+// quietHours is a schedule of the periods when messages are sent without notifications or held until the period ends.
+type quietHours struct {
+	windows  []*quietWindow
+	holidays map[string]bool
+	location *time.Location
+	hold     bool
+}
+
+func newQuietHours(opts map[string]string) (*quietHours, error) {
+	q := &quietHours{
+		holidays: map[string]bool{},
+		location: time.Local,
+	}
+
+	if val, ok := opts["quiet_hours"]; ok {
+		for _, spec := range strings.Split(val, ",") {
+			w, err := parseQuietWindow(strings.TrimSpace(spec))
+			if err != nil {
+				return nil, err
+			}
+			q.windows = append(q.windows, w)
+		}
+	}
+
+	if val, ok := opts["quiet_holidays"]; ok {
+		for _, day := range strings.Split(val, ",") {
+			day = strings.TrimSpace(day)
+			if _, err := time.Parse(time.DateOnly, day); err != nil {
+				return nil, eris.Wrapf(ErrInvalidChannelOption, "quiet_holidays: %s", day)
+			}
+			q.holidays[day] = true
+		}
+	}
+
+	if val, ok := opts["quiet_tz"]; ok {
+		loc, err := time.LoadLocation(val)
+		if err != nil {
+			return nil, eris.Wrapf(ErrInvalidChannelOption, "quiet_tz: %s", val)
+		}
+		q.location = loc
+	}
+
+	if val, ok := opts["quiet_mode"]; ok {
+		if val != quietModeSilent && val != quietModeHold {
+			return nil, eris.Wrapf(ErrInvalidChannelOption, "quiet_mode: %s", val)
+		}
+		q.hold = val == quietModeHold
+	}
+
+	return q, nil
+}
+
+// parseQuietWindow parses the window formatted as "[days/]HH:MM-HH:MM",
+// where days are a weekday or a range of weekdays: "sat", "mon-fri".
+func parseQuietWindow(spec string) (*quietWindow, error) {
+	w := &quietWindow{}
+	invalid := eris.Wrapf(ErrInvalidChannelOption, "quiet_hours: %s", spec)
+
+	period := spec
+	if days, rest, ok := strings.Cut(spec, "/"); ok {
+		period = rest
+		first, last, isRange := strings.Cut(strings.ToLower(days), "-")
+		if !isRange {
+			last = first
+		}
+		from, ok1 := _weekdays[first]
+		to, ok2 := _weekdays[last]
+		if !ok1 || !ok2 {
+			return nil, invalid
+		}
+		for d := from; ; d = (d + 1) % daysInWeek {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	} else {
+		for d := range w.days {
+			w.days[d] = true
+		}
+	}
+
+	start, end, ok := strings.Cut(period, "-")
+	if !ok {
+		return nil, invalid
+	}
+	var err1, err2 error
+	w.start, err1 = parseClock(start)
+	w.end, err2 = parseClock(end)
+	if err1 != nil || err2 != nil || w.start == w.end || w.start == minutesInDay {
+		return nil, invalid
+	}
+
+	return w, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight. "24:00" is allowed as the end of a day.
+func parseClock(val string) (int, error) {
+	hours, minutes, ok := strings.Cut(val, ":")
+	if !ok {
+		return 0, ErrInvalidChannelOption
+	}
+	h, err1 := strconv.Atoi(hours)
+	m, err2 := strconv.Atoi(minutes)
+	res := h*minutesInHour + m
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m >= minutesInHour || res > minutesInDay {
+		return 0, ErrInvalidChannelOption
+	}
+	return res, nil
+}
+
+func (q *quietHours) enabled() bool {
+	return len(q.windows) > 0 || len(q.holidays) > 0
+}
+
+// until returns the end of the quiet period which contains the time.
+// The adjacent windows and holidays are joined into one period.
+func (q *quietHours) until(t time.Time) (time.Time, bool) {
+	end, ok := q.periodEnd(t)
+	if !ok {
+		return time.Time{}, false
+	}
+	for i := 0; i < quietPeriodMaxExtensions; i++ {
+		next, ok := q.periodEnd(end)
+		if !ok || !next.After(end) {
+			break
+		}
+		end = next
+	}
+	return end, true
+}
+
+func (q *quietHours) periodEnd(t time.Time) (time.Time, bool) {
+	t = t.In(q.location)
+	atMinute := func(minute int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, minute, 0, 0, q.location)
+	}
+	minute := t.Hour()*minutesInHour + t.Minute()
+
+	var end time.Time
+	found := false
+	extend := func(candidate time.Time) {
+		if !found || candidate.After(end) {
+			end = candidate
+		}
+		found = true
+	}
+
+	if q.holidays[t.Format(time.DateOnly)] {
+		extend(atMinute(minutesInDay))
+	}
+
+	yesterday := (t.Weekday() + daysInWeek - 1) % daysInWeek
+	for _, w := range q.windows {
+		switch {
+		case w.start < w.end:
+			if w.days[t.Weekday()] && minute >= w.start && minute < w.end {
+				extend(atMinute(w.end))
+			}
+		case w.days[t.Weekday()] && minute >= w.start:
+			extend(atMinute(minutesInDay + w.end))
+		case w.days[yesterday] && minute < w.end:
+			extend(atMinute(w.end))
+		}
+	}
+
+	return end, found
+}
@@ -0,0 +1,195 @@
+package channels
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// SentMessagesRegistryCap limits the number of messages which could be edited or deleted.
+var SentMessagesRegistryCap = 10000
+
+const messageIDBytes = 8
+
+// Delivery statuses of the messages.
+const (
+	MessageStatusQueued = "queued"
+	MessageStatusSent   = "sent"
+	MessageStatusFailed = "failed"
+)
+
+// MessageStatus is a delivery status of the enqueued message.
+// DeliveredBy is the name of the channel which delivered the message, the channel itself or one of its fallbacks.
+// The messages with the Acknowledge button have the name of the user who pressed it and the time.
+type MessageStatus struct {
+	ID             string     `json:"id"`
+	Key            string     `json:"key,omitempty"`
+	Status         string     `json:"status"`
+	DeliveredBy    string     `json:"deliveredBy,omitempty"`
+	AcknowledgedBy string     `json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty"`
+}
+
+// sentMessage links the proxy-assigned message ID with the IDs of the messages in the chat.
+// The messages delivered by the fallback channels have no IDs, they can't be edited.
+type sentMessage struct {
+	id          string
+	key         string
+	priority    int
+	providerIDs []int
+	status      string
+	deliveredBy string
+
+	// ackMessage is kept to edit the message when it is acknowledged.
+	ackMessage *TelegramMessage
+	ackedBy    string
+	ackedAt    time.Time
+}
+
+// messageRegistry is a bounded map of the enqueued messages.
+// The oldest messages are forgotten when the registry is full.
+type messageRegistry struct {
+	mu       sync.Mutex
+	capacity int
+	messages map[string]*list.Element
+	keys     map[string]string
+	order    *list.List
+}
+
+func newMessageRegistry(capacity int) *messageRegistry {
+	return &messageRegistry{
+		capacity: capacity,
+		messages: map[string]*list.Element{},
+		keys:     map[string]string{},
+		order:    list.New(),
+	}
+}
+
+func newMessageID() string {
+	buf := make([]byte, messageIDBytes)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (r *messageRegistry) add(id string, key string, priority int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeLocked(id)
+	r.messages[id] = r.order.PushBack(&sentMessage{
+		id:       id,
+		key:      key,
+		priority: priority,
+		status:   MessageStatusQueued,
+	})
+	if key != "" {
+		r.keys[key] = id
+	}
+
+	for r.order.Len() > r.capacity {
+		r.removeLocked(r.order.Front().Value.(*sentMessage).id) //nolint:forcetypeassert
+	}
+}
+
+// resolve finds a message by the proxy-assigned ID or by the client correlation key.
+func (r *messageRegistry) resolve(idOrKey string) (*sentMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id, ok := r.keys[idOrKey]; ok {
+		idOrKey = id
+	}
+	el, ok := r.messages[idOrKey]
+	if !ok {
+		return nil, false
+	}
+
+	res := *el.Value.(*sentMessage) //nolint:forcetypeassert
+	return &res, true
+}
+
+func (r *messageRegistry) setProviderIDs(id string, providerIDs []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if el, ok := r.messages[id]; ok {
+		el.Value.(*sentMessage).providerIDs = providerIDs //nolint:forcetypeassert
+	}
+}
+
+func (r *messageRegistry) setDeliveredBy(id string, channel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if el, ok := r.messages[id]; ok {
+		sm := el.Value.(*sentMessage) //nolint:forcetypeassert
+		sm.status = MessageStatusSent
+		sm.deliveredBy = channel
+	}
+}
+
+func (r *messageRegistry) setFailed(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if el, ok := r.messages[id]; ok {
+		el.Value.(*sentMessage).status = MessageStatusFailed //nolint:forcetypeassert
+	}
+}
+
+func (r *messageRegistry) setAckMessage(id string, message *TelegramMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if el, ok := r.messages[id]; ok {
+		el.Value.(*sentMessage).ackMessage = message //nolint:forcetypeassert
+	}
+}
+
+// acknowledge marks the message as acknowledged. It returns false if the message is already acknowledged.
+func (r *messageRegistry) acknowledge(idOrKey string, by string, at time.Time) (*sentMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id, ok := r.keys[idOrKey]; ok {
+		idOrKey = id
+	}
+	el, ok := r.messages[idOrKey]
+	if !ok {
+		return nil, false
+	}
+
+	sm := el.Value.(*sentMessage) //nolint:forcetypeassert
+	first := sm.ackedBy == ""
+	if first {
+		sm.ackedBy = by
+		sm.ackedAt = at
+	}
+
+	res := *sm
+	return &res, first
+}
+
+func (r *messageRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeLocked(id)
+}
+
+func (r *messageRegistry) removeLocked(id string) {
+	el, ok := r.messages[id]
+	if !ok {
+		return
+	}
+
+	m := r.order.Remove(el).(*sentMessage) //nolint:forcetypeassert
+	delete(r.messages, id)
+	if m.key != "" && r.keys[m.key] == id {
+		delete(r.keys, m.key)
+	}
+}
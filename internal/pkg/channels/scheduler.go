@@ -0,0 +1,215 @@
+package channels
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rotisserie/eris"
+	"github.com/rs/zerolog"
+
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/storage"
+)
+
+const (
+	scheduledStateBucket = "scheduled"
+	scheduleRetryDelay   = 1 * time.Second
+	scheduleIdleWait     = 1 * time.Hour
+)
+
+// ScheduledMessage is a message held by the scheduler until its due time.
+// ExpiresAt is kept for the messages held by the quiet hours, so they expire as if they were queued.
+// The messages scheduled by the client have no ExpiresAt, their ttl is counted from the due time.
+type ScheduledMessage struct {
+	ID        string           `json:"id"`
+	SendAt    time.Time        `json:"sendAt"`
+	ExpiresAt *time.Time       `json:"expiresAt,omitempty"`
+	Message   *TelegramMessage `json:"message"`
+}
+
+type scheduleHeap []*ScheduledMessage
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[i].SendAt.Before(h[j].SendAt) }
+func (h scheduleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *scheduleHeap) Push(x interface{}) {
+	*h = append(*h, x.(*ScheduledMessage)) //nolint:forcetypeassert
+}
+
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// messageScheduler holds the future messages and hands them to the channel queue at their due time.
+// The messages are kept in the channel state, so they survive restarts when the state is persistent.
+type messageScheduler struct {
+	mu sync.Mutex
+
+	items   map[string]*ScheduledMessage
+	queue   scheduleHeap
+	wakeup  chan struct{}
+	state   storage.Storage
+	deliver func(*ScheduledMessage) error
+	logger  *zerolog.Logger
+}
+
+func newMessageScheduler(state storage.Storage, deliver func(*ScheduledMessage) error, logger *zerolog.Logger) (*messageScheduler, error) {
+	s := &messageScheduler{
+		items:   map[string]*ScheduledMessage{},
+		wakeup:  make(chan struct{}, 1),
+		state:   state,
+		deliver: deliver,
+		logger:  logger,
+	}
+
+	ids, err := state.Keys(scheduledStateBucket)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		sm := &ScheduledMessage{}
+		if _, err := state.Get(scheduledStateBucket, id, sm); err != nil {
+			return nil, err
+		}
+		s.items[sm.ID] = sm
+		heap.Push(&s.queue, sm)
+	}
+
+	return s, nil
+}
+
+func (s *messageScheduler) add(sm *ScheduledMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.state.Put(scheduledStateBucket, sm.ID, sm); err != nil {
+		return err
+	}
+	s.items[sm.ID] = sm
+	heap.Push(&s.queue, sm)
+	s.notify()
+
+	return nil
+}
+
+func (s *messageScheduler) cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sm, ok := s.items[id]
+	if !ok {
+		return eris.Wrap(ErrMessageNotFound, id)
+	}
+	for i, item := range s.queue {
+		if item == sm {
+			heap.Remove(&s.queue, i)
+			break
+		}
+	}
+	delete(s.items, id)
+	s.notify()
+
+	return s.state.Delete(scheduledStateBucket, id)
+}
+
+func (s *messageScheduler) list() []*ScheduledMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]*ScheduledMessage, 0, len(s.items))
+	for _, sm := range s.items {
+		result = append(result, sm)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].SendAt.Before(result[j].SendAt)
+	})
+	return result
+}
+
+func (s *messageScheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers the due messages until the done channel is closed.
+func (s *messageScheduler) run(done chan bool) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		wait := s.deliverDue(time.Now())
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-done:
+			return
+		case <-s.wakeup:
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverDue hands the due messages to the channel and returns the time to wait for the next one.
+// The messages are delivered without the lock, so a blocked queue doesn't block the listing and cancelling.
+func (s *messageScheduler) deliverDue(now time.Time) time.Duration {
+	due := s.takeDue(now)
+	for i, sm := range due {
+		if err := s.deliver(sm); err != nil {
+			s.logger.Error().Msgf("Failed to enqueue scheduled message %s, retry in %s: %s", sm.ID, scheduleRetryDelay, err)
+			s.restore(due[i:])
+			return scheduleRetryDelay
+		}
+
+		if err := s.state.Delete(scheduledStateBucket, sm.ID); err != nil {
+			s.logger.Error().Msgf("Failed to remove scheduled message %s from the state: %s", sm.ID, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.queue.Len() > 0 {
+		return time.Until(s.queue[0].SendAt)
+	}
+	return scheduleIdleWait
+}
+
+// takeDue removes the due messages from the queue, they are kept in the state until they are delivered.
+func (s *messageScheduler) takeDue(now time.Time) []*ScheduledMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*ScheduledMessage{}
+	for s.queue.Len() > 0 && !s.queue[0].SendAt.After(now) {
+		sm := heap.Pop(&s.queue).(*ScheduledMessage) //nolint:forcetypeassert
+		delete(s.items, sm.ID)
+		due = append(due, sm)
+	}
+	return due
+}
+
+// restore returns the undelivered messages to the queue.
+func (s *messageScheduler) restore(messages []*ScheduledMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sm := range messages {
+		s.items[sm.ID] = sm
+		heap.Push(&s.queue, sm)
+	}
+}
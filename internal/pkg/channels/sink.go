@@ -0,0 +1,233 @@
+package channels
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rotisserie/eris"
+	"github.com/rs/zerolog"
+)
+
+var ErrSinkInvalidOptions = eris.New("Invalid sink channel options")
+
+var _fileSinkOptions = []string{
+	"name",
+	"max_size",
+	"max_files",
+}
+
+const (
+	fileSinkDefaultMaxSize  = 10 << 20
+	fileSinkDefaultMaxFiles = 5
+	fileSinkPerm            = 0o644
+)
+
+// Operations of the sink records.
+const (
+	sinkOperationSend   = "send"
+	sinkOperationEdit   = "edit"
+	sinkOperationDelete = "delete"
+)
+
+// NewFileChannel creates a channel which appends the messages as JSON lines to the file:
+// file:///var/log/tgp/messages.jsonl?name=channel_name.
+func NewFileChannel(chanURL *url.URL, logger *zerolog.Logger) (MessageChannelInterface, error) {
+	options := channelOptions(chanURL, _fileSinkOptions, _queuedChannelOptions)
+	if options["name"] == "" {
+		return nil, eris.Wrap(ErrSinkInvalidOptions, "name is required")
+	}
+	if chanURL.Path == "" {
+		return nil, eris.Wrap(ErrSinkInvalidOptions, "path of the file is required")
+	}
+
+	out, err := newRotatedFile(chanURL.Path, channelOptions(chanURL, _fileSinkOptions))
+	if err != nil {
+		return nil, err
+	}
+
+	return newQueuedChannel(chanURL, newMessageSink(out, logger), options, logger)
+}
+
+// NewStdoutChannel creates a channel which prints the messages as JSON lines: stdout:///channel_name.
+func NewStdoutChannel(chanURL *url.URL, logger *zerolog.Logger) (MessageChannelInterface, error) {
+	return newQueuedChannel(
+		chanURL, newMessageSink(os.Stdout, logger),
+		channelOptions(chanURL, _queuedChannelOptions),
+		logger,
+	)
+}
+
+// sinkRecord is a line of the sink output.
+type sinkRecord struct {
+	Operation string                    `json:"operation"`
+	IDs       []int                     `json:"ids"`
+	At        time.Time                 `json:"at"`
+	Message   *TelegramMessage          `json:"message,omitempty"`
+	Files     map[string]sinkRecordFile `json:"files,omitempty"`
+}
+
+type sinkRecordFile struct {
+	FileName string `json:"fileName"`
+	Size     int    `json:"size"`
+}
+
+// messageSink writes the sent, edited and deleted messages instead of delivering them,
+// so the API can be exercised without the real services.
+type messageSink struct {
+	mu     sync.Mutex
+	out    io.Writer
+	lastID int
+	logger *zerolog.Logger
+}
+
+func newMessageSink(out io.Writer, logger *zerolog.Logger) *messageSink {
+	return &messageSink{
+		out:    out,
+		logger: logger,
+	}
+}
+
+// HTTPClient returns nil, the sinks don't use HTTP.
+func (ms *messageSink) HTTPClient() *http.Client {
+	return nil
+}
+
+func (ms *messageSink) SendMessage(message *TelegramMessage) ([]int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.lastID++
+	ids := []int{ms.lastID}
+	if err := ms.write(sinkOperationSend, ids, message); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (ms *messageSink) EditMessage(messageIDs []int, message *TelegramMessage) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.write(sinkOperationEdit, messageIDs, message)
+}
+
+func (ms *messageSink) DeleteMessage(messageIDs []int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.write(sinkOperationDelete, messageIDs, nil)
+}
+
+func (ms *messageSink) write(operation string, ids []int, message *TelegramMessage) error {
+	record := sinkRecord{
+		Operation: operation,
+		IDs:       ids,
+		At:        time.Now().UTC(),
+		Message:   message,
+	}
+	if message != nil && len(message.files) > 0 {
+		record.Files = map[string]sinkRecordFile{}
+		for name, file := range message.files {
+			record.Files[name] = sinkRecordFile{FileName: file.FileName, Size: len(file.Content)}
+		}
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return eris.Wrap(err, "Error on encode message")
+	}
+	if _, err := ms.out.Write(append(line, '\n')); err != nil {
+		return eris.Wrap(err, "Error on write message")
+	}
+	return nil
+}
+
+// rotatedFile is an append-only file which is rotated when it exceeds the max size:
+// messages.jsonl is renamed to messages.jsonl.1, messages.jsonl.1 to messages.jsonl.2 and so on,
+// the oldest file over max_files is overwritten.
+type rotatedFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newRotatedFile(path string, opts map[string]string) (*rotatedFile, error) {
+	rf := &rotatedFile{
+		path:     path,
+		maxSize:  fileSinkDefaultMaxSize,
+		maxFiles: fileSinkDefaultMaxFiles,
+	}
+
+	if val, ok := opts["max_size"]; ok {
+		size, err := parsePositiveIntOption("max_size", val)
+		if err != nil {
+			return nil, err
+		}
+		rf.maxSize = int64(size)
+	}
+	if val, ok := opts["max_files"]; ok {
+		var err error
+		if rf.maxFiles, err = parsePositiveIntOption("max_files", val); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatedFile) Write(line []byte) (int, error) {
+	if rf.size > 0 && rf.size+int64(len(line)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(line)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatedFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileSinkPerm)
+	if err != nil {
+		return eris.Wrapf(err, "Failed to open sink file %s", rf.path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return eris.Wrapf(err, "Failed to open sink file %s", rf.path)
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatedFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return eris.Wrapf(err, "Failed to close sink file %s", rf.path)
+	}
+
+	for i := rf.maxFiles - 1; i >= 0; i-- {
+		src := rf.path
+		if i > 0 {
+			src = fmt.Sprintf("%s.%d", rf.path, i)
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", rf.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return eris.Wrapf(err, "Failed to rotate sink file %s", src)
+		}
+	}
+
+	return rf.open()
+}
@@ -0,0 +1,157 @@
+package channels
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rotisserie/eris"
+	"github.com/rs/zerolog"
+)
+
+var ErrSlackInvalidWebhook = eris.New("Invalid slack webhook")
+
+var _slackProviderOptions = []string{
+	"timeout",
+	"username",
+	"icon_emoji",
+	"icon_url",
+	"channel",
+}
+
+const slackWebhooksPath = "/services/"
+
+// NewSlackChannel creates a channel of the Slack incoming webhook.
+// The webhook https://hooks.slack.com/services/T000/B000/XXXX is set as slack://T000:B000:XXXX@hooks.slack.com/channel_name.
+func NewSlackChannel(chanURL *url.URL, logger *zerolog.Logger) (MessageChannelInterface, error) {
+	secret, ok := chanURL.User.Password()
+	if chanURL.User.Username() == "" || !ok || secret == "" {
+		return nil, eris.Wrap(ErrSlackInvalidWebhook, "webhook must be set as team:bot:secret")
+	}
+	webhookPath := slackWebhooksPath + chanURL.User.Username() + "/" + strings.ReplaceAll(secret, ":", "/")
+
+	provider, err := NewSlackWebhook("https://"+chanURL.Host, webhookPath, channelOptions(chanURL, _slackProviderOptions), logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return newQueuedChannel(
+		chanURL, provider,
+		channelOptions(chanURL, _slackProviderOptions, _queuedChannelOptions),
+		logger,
+	)
+}
+
+type slackWebhook struct {
+	httpClient *resty.Client
+	path       string
+	params     map[string]string
+	logger     *zerolog.Logger
+}
+
+func NewSlackWebhook(baseURL string, path string, opts map[string]string, logger *zerolog.Logger) (*slackWebhook, error) {
+	timeout := httpTimeout
+	if val, ok := opts["timeout"]; ok {
+		var err error
+		if timeout, err = ParseDurationOption("timeout", val); err != nil {
+			return nil, err
+		}
+	}
+
+	// username, icon_emoji, icon_url and channel override the defaults of the webhook.
+	params := map[string]string{}
+	for k, v := range opts {
+		if k != "timeout" {
+			params[k] = v
+		}
+	}
+
+	httpClient := resty.New().
+		SetBaseURL(baseURL).
+		SetTimeout(timeout).
+		SetRedirectPolicy(resty.NoRedirectPolicy()).
+		SetRetryCount(httpRetries).
+		SetRetryMaxWaitTime(httpMaxWaitTime).
+		SetRetryAfter(retryAfterHeader).
+		AddRetryCondition(
+			func(r *resty.Response, err error) bool {
+				return r.StatusCode() == http.StatusTooManyRequests || r.StatusCode() >= http.StatusInternalServerError
+			},
+		)
+
+	provider := &slackWebhook{
+		httpClient: httpClient,
+		path:       path,
+		params:     params,
+		logger:     logger,
+	}
+	return provider, nil
+}
+
+func (sw *slackWebhook) HTTPClient() *http.Client {
+	return sw.httpClient.GetClient()
+}
+
+// ValidateMessage rejects the fields which can't be sent by the incoming webhooks.
+func (sw *slackWebhook) ValidateMessage(message *TelegramMessage) error {
+	return validateTextMessage(message, "slack")
+}
+
+// SendMessage posts the message to the webhook. The webhooks don't return IDs of the messages.
+func (sw *slackWebhook) SendMessage(message *TelegramMessage) ([]int, error) {
+	payload := map[string]interface{}{
+		"text":         richSlackMrkdwn(parseRichText(message.Text, message.ParseMode)),
+		"mrkdwn":       true,
+		"unfurl_links": message.DisableWebPagePreview == 0,
+		"unfurl_media": message.DisableWebPagePreview == 0,
+	}
+	for k, v := range sw.params {
+		payload[k] = v
+	}
+
+	res, err := sw.httpClient.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(payload).
+		Post(sw.path)
+	if err != nil {
+		return nil, eris.Wrap(err, "Error on send message")
+	}
+	if res.IsError() {
+		return nil, eris.New(
+			fmt.Sprintf(
+				"Failed request to Slack webhook: status: %d, body: %s",
+				res.StatusCode(), res.Body(),
+			),
+		)
+	}
+
+	return nil, nil
+}
+
+// retryAfterHeader waits for the time from the Retry-After header of the rate limited response.
+func retryAfterHeader(_ *resty.Client, r *resty.Response) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(r.Header().Get("Retry-After"), 64)
+	if err != nil || seconds <= 0 {
+		return 0, nil //nolint:nilerr
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// validateTextMessage rejects the Telegram-specific fields for the channels which send only the text.
+func validateTextMessage(message *TelegramMessage, service string) error {
+	switch {
+	case len(message.Media) > 0 || len(message.files) > 0:
+		return eris.Wrapf(ErrInvalidMessage, "%s channels don't support media", service)
+	case message.ReplyMarkup != nil:
+		return eris.Wrapf(ErrInvalidMessage, "%s channels don't support inline keyboards", service)
+	case message.Topic != "" || message.MessageThreadID != nil:
+		return eris.Wrapf(ErrInvalidMessage, "%s channels don't support topics", service)
+	case message.ReplyToMessageID != nil:
+		return eris.Wrapf(ErrInvalidMessage, "%s channels don't support replies", service)
+	}
+	return nil
+}
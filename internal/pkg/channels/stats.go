@@ -0,0 +1,36 @@
+package channels
+
+import (
+	"sync/atomic"
+)
+
+// ChannelStats is a snapshot of the channel counters.
+type ChannelStats struct {
+	Queued    int    `json:"queued"`
+	Spilled   int    `json:"spilled"`
+	Scheduled int    `json:"scheduled"`
+	Enqueued  uint64 `json:"enqueued"`
+	Sent      uint64 `json:"sent"`
+	Failed    uint64 `json:"failed"`
+	Expired   uint64 `json:"expired"`
+	Shed      uint64 `json:"shed"`
+}
+
+// channelStats counts the messages processed by the channel since the start of the program.
+type channelStats struct {
+	enqueued atomic.Uint64
+	sent     atomic.Uint64
+	failed   atomic.Uint64
+	expired  atomic.Uint64
+	shed     atomic.Uint64
+}
+
+func (s *channelStats) snapshot() *ChannelStats {
+	return &ChannelStats{
+		Enqueued: s.enqueued.Load(),
+		Sent:     s.sent.Load(),
+		Failed:   s.failed.Load(),
+		Expired:  s.expired.Load(),
+		Shed:     s.shed.Load(),
+	}
+}
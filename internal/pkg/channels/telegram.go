@@ -1,13 +1,14 @@
 package channels
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -19,17 +20,29 @@ import (
 var (
 	ErrChannelIsFull      = eris.New("The channel is full")
 	ErrInvalidMessageType = eris.New("Invalid message type")
+	ErrInvalidMessage     = eris.New("Invalid message")
+	ErrMessageWasNotSent  = eris.New("The message wasn't sent")
 )
 
 var (
 	TelegramMessageQueueCap = 1000
-	TelegramBotAPIURL       = "https://api.telegram.org"
+	// TelegramBotAPIURL is the default Bot API server, the api_url option of the channel overrides it.
+	TelegramBotAPIURL = "https://api.telegram.org"
 )
 
 var _telegramProviderOptions = []string{
 	"timeout",
 }
 
+var _telegramChannelOptions = []string{
+	"topic",
+	"auto_topics",
+	"api_url",
+	"ack",
+	"ack_text",
+	"ack_hook",
+}
+
 var telegramFatalHTTPStatusCodes = map[int]bool{
 	404: true,
 	400: true,
@@ -41,14 +54,82 @@ const (
 	httpMaxWaitTime = 32 * time.Second
 )
 
+const (
+	telegramMediaGroupMinSize = 2
+	telegramMediaGroupMaxSize = 10
+	telegramCaptionMaxLength  = 1024
+	telegramAttachPrefix      = "attach://"
+)
+
+// Media types allowed in the media groups and the groups of types which may be mixed in one album.
+var telegramMediaGroupKinds = map[string]string{
+	"photo":    "visual",
+	"video":    "visual",
+	"document": "document",
+	"audio":    "audio",
+}
+
 var ErrTelegramInvalidTimeout = eris.New("Invalid telegram timeout")
 
 type TelegramMessage struct {
-	Text                  string  `json:"text" mapstructure:"text"`
-	ParseMode             *string `json:"parseMode,omitempty" mapstructure:"parse_mode,omitempty"`
-	DisableWebPagePreview int     `json:"disableWebPagePreview" mapstructure:"disable_web_page_preview"`
-	DisableNotifications  int     `json:"disableNotifications" mapstructure:"disable_notifications"`
-	ReplyToMessageID      *int    `json:"replyToMessageId,omitempty" mapstructure:"reply_to_message_id,omitempty"`
+	Text                  string                `json:"text" mapstructure:"text"`
+	ParseMode             *string               `json:"parseMode,omitempty" mapstructure:"parse_mode,omitempty"`
+	DisableWebPagePreview int                   `json:"disableWebPagePreview" mapstructure:"disable_web_page_preview"`
+	DisableNotifications  int                   `json:"disableNotifications" mapstructure:"disable_notifications"`
+	ReplyToMessageID      *int                  `json:"replyToMessageId,omitempty" mapstructure:"reply_to_message_id,omitempty"`
+	MessageThreadID       *int                  `json:"messageThreadId,omitempty" mapstructure:"message_thread_id,omitempty"`
+	Media                 []*TelegramInputMedia `json:"media,omitempty" mapstructure:"-"`
+
+	ReplyMarkup *TelegramInlineKeyboardMarkup `json:"replyMarkup,omitempty" mapstructure:"-"`
+
+	// Key is a client correlation key which may be used instead of the message ID to edit or delete the message.
+	Key string `json:"key,omitempty" mapstructure:"-"`
+
+	// DedupKey identifies the repeated messages instead of the text, if the channel suppresses duplicates.
+	DedupKey string `json:"dedupKey,omitempty" mapstructure:"-"`
+
+	// Priority is one of low, normal, high or critical. Critical messages ignore the quiet hours.
+	Priority string `json:"priority,omitempty" mapstructure:"-"`
+
+	// SendAt or Delay schedule the message for the future delivery.
+	SendAt *time.Time `json:"sendAt,omitempty" mapstructure:"-"`
+	Delay  string     `json:"delay,omitempty" mapstructure:"-"`
+
+	// ExpiresAt or TTL drop the message if it isn't sent in time. TTL counts from the enqueueing of the message.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" mapstructure:"-"`
+	TTL       string     `json:"ttl,omitempty" mapstructure:"-"`
+
+	// GroupKey groups the messages in digests, if the channel batches messages.
+	GroupKey string `json:"groupKey,omitempty" mapstructure:"-"`
+
+	// Topic is a name of the forum topic. The topic is created on first use, if the channel has auto topics enabled.
+	Topic string `json:"topic,omitempty" mapstructure:"-"`
+
+	// Ack adds the Acknowledge button to the message.
+	Ack bool `json:"ack,omitempty" mapstructure:"-"`
+
+	files map[string]*TelegramFile
+}
+
+// TelegramInputMedia is an item of a media group (album).
+// Media is an URL, a Telegram file_id or an "attach://<name>" reference to an uploaded file.
+type TelegramInputMedia struct {
+	Type  string `json:"type" mapstructure:"type"`
+	Media string `json:"media" mapstructure:"media"`
+}
+
+func (im *TelegramInputMedia) Map() (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	if err := mapstructure.Decode(im, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// TelegramFile is a file uploaded with a message via multipart request.
+type TelegramFile struct {
+	FileName string
+	Content  []byte
 }
 
 func (m *TelegramMessage) String() string {
@@ -64,138 +145,224 @@ func (m *TelegramMessage) Map() (map[string]interface{}, error) {
 	if err := mapstructure.Decode(m, &res); err != nil {
 		return nil, err
 	}
+
+	if len(m.Media) > 0 {
+		media := []map[string]interface{}{}
+		for i, item := range m.Media {
+			im, err := item.Map()
+			if err != nil {
+				return nil, err
+			}
+			// The caption of the first item is shown as the caption of the whole album.
+			if i == 0 && m.Text != "" {
+				im["caption"] = m.Text
+				if m.ParseMode != nil {
+					im["parse_mode"] = *m.ParseMode
+				}
+			}
+			media = append(media, im)
+		}
+		res["media"] = media
+		delete(res, "text")
+		delete(res, "parse_mode")
+		delete(res, "disable_web_page_preview")
+	}
+
+	if m.ReplyMarkup != nil {
+		markup, err := m.ReplyMarkup.Map()
+		if err != nil {
+			return nil, err
+		}
+		res["reply_markup"] = markup
+	}
+
 	return res, nil
 }
 
-type telegramProviderInterface interface {
-	SendMessage(*TelegramMessage) error
-	HTTPClient() *http.Client
+func (m *TelegramMessage) AttachFile(name string, fileName string, content []byte) error {
+	if name == "" {
+		return eris.Wrap(ErrInvalidMessage, "file name is empty")
+	}
+	if m.files == nil {
+		m.files = map[string]*TelegramFile{}
+	}
+	m.files[name] = &TelegramFile{
+		FileName: fileName,
+		Content:  content,
+	}
+	return nil
 }
 
-type telegramChannel struct {
-	chanURL *url.URL
-
-	logger       *zerolog.Logger
-	name         string
-	queue        chan *TelegramMessage
-	provider     telegramProviderInterface
-	providerOpts map[string]string
-
-	mu            sync.Mutex
-	doneProcesors chan bool
+func (m *TelegramMessage) Files() map[string]*TelegramFile {
+	return m.files
 }
 
-func NewTelegramChannel(chanURL *url.URL, logger *zerolog.Logger) (MessageChannelInterface, error) {
-	providerOpts := map[string]string{}
-	for _, opt := range _telegramProviderOptions {
-		if val := chanURL.Query().Get(opt); val != "" {
-			providerOpts[opt] = val
+func (m *TelegramMessage) Validate() error {
+	if err := validatePriority(m.Priority); err != nil {
+		return err
+	}
+	if _, err := m.scheduledAt(time.Now()); err != nil {
+		return err
+	}
+	if _, err := m.expiresAt(time.Now(), 0); err != nil {
+		return err
+	}
+
+	if m.ReplyMarkup != nil {
+		if err := m.ReplyMarkup.Validate(); err != nil {
+			return err
 		}
 	}
-	provider, err := NewTelegramChat(TelegramBotAPIURL, chanURL.User.String(), chanURL.Host, providerOpts, logger)
-	if err != nil {
-		return nil, err
+
+	if len([]rune(m.Topic)) > telegramTopicNameMaxLength {
+		return eris.Wrapf(ErrInvalidMessage, "topic name is longer than %d characters", telegramTopicNameMaxLength)
 	}
 
-	channel := &telegramChannel{
-		chanURL:      chanURL,
-		logger:       logger,
-		name:         strings.Trim(chanURL.Path, "/"),
-		provider:     provider,
-		providerOpts: providerOpts,
-		queue:        make(chan *TelegramMessage, TelegramMessageQueueCap),
+	if len(m.Media) == 0 {
+		if len(m.files) > 0 {
+			return eris.Wrap(ErrInvalidMessage, "uploaded files are allowed only in media groups")
+		}
+		return nil
 	}
 
-	return channel, nil
+	return m.validateMediaGroup()
 }
 
-func (ch *telegramChannel) Stop() error {
-	ch.mu.Lock()
-	defer ch.mu.Unlock()
-
-	if ch.doneProcesors != nil {
-		close(ch.doneProcesors)
+func (m *TelegramMessage) validateMediaGroup() error {
+	if len(m.Media) < telegramMediaGroupMinSize || len(m.Media) > telegramMediaGroupMaxSize {
+		return eris.Wrapf(ErrInvalidMessage, "media group must contain from %d to %d items", telegramMediaGroupMinSize, telegramMediaGroupMaxSize)
+	}
+	if m.ReplyMarkup != nil {
+		return eris.Wrap(ErrInvalidMessage, "media groups don't support inline keyboards")
+	}
+	if len([]rune(m.Text)) > telegramCaptionMaxLength {
+		return eris.Wrapf(ErrInvalidMessage, "media group caption is longer than %d characters", telegramCaptionMaxLength)
 	}
-	return nil
-}
 
-func (ch *telegramChannel) Start() error {
-	ch.mu.Lock()
-	defer ch.mu.Unlock()
+	groupKind := ""
+	for i, item := range m.Media {
+		if item == nil {
+			return eris.Wrapf(ErrInvalidMessage, "media #%d is empty", i)
+		}
+		kind, ok := telegramMediaGroupKinds[item.Type]
+		if !ok {
+			return eris.Wrapf(ErrInvalidMessage, "media #%d has unsupported type %q", i, item.Type)
+		}
+		if groupKind != "" && kind != groupKind {
+			return eris.Wrapf(ErrInvalidMessage, "media #%d: type %q can't be mixed with other media types of the group", i, item.Type)
+		}
+		groupKind = kind
 
-	if ch.doneProcesors != nil {
-		close(ch.doneProcesors)
+		if item.Media == "" {
+			return eris.Wrapf(ErrInvalidMessage, "media #%d has no file", i)
+		}
+		if name, ok := strings.CutPrefix(item.Media, telegramAttachPrefix); ok {
+			if _, ok := m.files[name]; !ok {
+				return eris.Wrapf(ErrInvalidMessage, "media #%d refers to unknown uploaded file %q", i, name)
+			}
+		}
 	}
 
-	done := make(chan bool, 1)
-	go ch.processQueue(done)
-	ch.doneProcesors = done
-
 	return nil
 }
 
-func (ch *telegramChannel) String() string {
-	opts := []string{}
-	for k, v := range ch.providerOpts {
-		opts = append(opts, k+"="+v)
+// scheduledAt returns the time of the delayed delivery or the zero time for immediate messages.
+func (m *TelegramMessage) scheduledAt(now time.Time) (time.Time, error) {
+	if m.SendAt != nil && m.Delay != "" {
+		return time.Time{}, eris.Wrap(ErrInvalidMessage, "sendAt and delay can't be used together")
 	}
-	fmt.Print(opts)
 
-	var sOpts string
-	if len(opts) > 0 {
-		sOpts = "?" + strings.Join(opts, "&")
+	var sendAt time.Time
+	switch {
+	case m.SendAt != nil:
+		sendAt = *m.SendAt
+	case m.Delay != "":
+		delay, err := time.ParseDuration(m.Delay)
+		if err != nil || delay < 0 {
+			return time.Time{}, eris.Wrapf(ErrInvalidMessage, "invalid delay %q", m.Delay)
+		}
+		sendAt = now.Add(delay)
 	}
 
-	return fmt.Sprintf(
-		"%s://%s:***@%s%s%s",
-		ch.chanURL.Scheme, ch.chanURL.User.Username(), ch.chanURL.Hostname(), ch.chanURL.Path, sOpts,
-	)
+	if !sendAt.After(now) {
+		return time.Time{}, nil
+	}
+	if len(m.files) > 0 {
+		return time.Time{}, eris.Wrap(ErrInvalidMessage, "messages with uploaded files can't be scheduled, use URLs or file IDs")
+	}
+	return sendAt, nil
 }
 
-func (ch *telegramChannel) Name() string {
-	return ch.name
-}
+// expiresAt returns the deadline of the delivery or the zero time for messages without the deadline.
+func (m *TelegramMessage) expiresAt(enqueuedAt time.Time, defaultTTL time.Duration) (time.Time, error) {
+	if m.ExpiresAt != nil && m.TTL != "" {
+		return time.Time{}, eris.Wrap(ErrInvalidMessage, "expiresAt and ttl can't be used together")
+	}
 
-func (ch *telegramChannel) Provider() interface{ HTTPClient() *http.Client } {
-	return ch.provider
+	switch {
+	case m.ExpiresAt != nil:
+		return *m.ExpiresAt, nil
+	case m.TTL != "":
+		ttl, err := time.ParseDuration(m.TTL)
+		if err != nil || ttl <= 0 {
+			return time.Time{}, eris.Wrapf(ErrInvalidMessage, "invalid ttl %q", m.TTL)
+		}
+		return enqueuedAt.Add(ttl), nil
+	case defaultTTL > 0:
+		return enqueuedAt.Add(defaultTTL), nil
+	}
+	return time.Time{}, nil
 }
 
-func (ch *telegramChannel) MessageContainer() interface{} {
-	return &TelegramMessage{}
+func (m *TelegramMessage) validateEdit() error {
+	if len(m.Media) > 0 || len(m.files) > 0 {
+		return eris.Wrap(ErrInvalidMessage, "media can't be edited")
+	}
+	// The edit without the text and the keyboard would remove the keyboard of the message.
+	if m.Text == "" && m.ReplyMarkup == nil {
+		return eris.Wrap(ErrInvalidMessage, "the edit needs the text or the reply markup")
+	}
+	return m.Validate()
 }
 
-func (ch *telegramChannel) Enqueue(newMessage interface{}) error {
-	message, ok := newMessage.(*TelegramMessage)
-	if !ok {
-		return ErrInvalidMessageType
+func NewTelegramChannel(chanURL *url.URL, logger *zerolog.Logger) (MessageChannelInterface, error) {
+	apiURL := TelegramBotAPIURL
+	if val := chanURL.Query().Get("api_url"); val != "" {
+		var err error
+		if apiURL, err = ParseAPIURLOption("api_url", val); err != nil {
+			return nil, err
+		}
 	}
-	select {
-	case ch.queue <- message:
-	default:
-		return ErrChannelIsFull
+
+	provider, err := NewTelegramChat(
+		apiURL, chanURL.User.String(), chanURL.Host,
+		channelOptions(chanURL, _telegramProviderOptions, _httpTransportOptions),
+		logger,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	ch.logger.Info().Msgf("Enqueue message: %s", message)
-	return nil
-}
+	options := channelOptions(chanURL, _telegramProviderOptions, _httpTransportOptions, _telegramChannelOptions, _queuedChannelOptions)
+	if val, ok := options["proxy"]; ok {
+		options["proxy"] = redactURL(val)
+	}
 
-func (ch *telegramChannel) processQueue(done chan bool) {
-	for {
-		select {
-		case <-done:
-			return
-		case m := <-ch.queue:
-			if err := ch.provider.SendMessage(m); err != nil {
-				ch.logger.Error().Msgf("Failed to send message: %s", err)
-				continue
-			}
-			ch.logger.Info().Msgf("Message successful sended: %s", m)
-		}
+	channel, err := newQueuedChannel(chanURL, provider, options, logger)
+	if err != nil {
+		return nil, err
+	}
+	// The hook is already set up, the options are kept for the description of the channel.
+	if val, ok := options["ack_hook"]; ok {
+		options["ack_hook"] = redactURL(val)
 	}
+
+	return channel, nil
 }
 
 type telegramChat struct {
 	httpClient *resty.Client
+	botToken   string
 	chatID     string
 	logger     *zerolog.Logger
 }
@@ -223,9 +390,13 @@ func NewTelegramChat(apiURL string, botToken string, chatID string, opts map[str
 				return telegramFatalHTTPStatusCodes[r.StatusCode()]
 			},
 		)
+	if err := ConfigureTransport(httpClient, opts); err != nil {
+		return nil, err
+	}
 
 	provider := &telegramChat{
 		httpClient: httpClient,
+		botToken:   botToken,
 		chatID:     chatID,
 		logger:     logger,
 	}
@@ -236,28 +407,141 @@ func (tc *telegramChat) HTTPClient() *http.Client {
 	return tc.httpClient.GetClient()
 }
 
-func (tc *telegramChat) SendMessage(message *TelegramMessage) error {
+func (tc *telegramChat) SendMessage(message *TelegramMessage) ([]int, error) {
 	mm, err := message.Map()
 	if err != nil {
-		return eris.Wrap(err, "Error on send message")
+		return nil, eris.Wrap(err, "Error on send message")
 	}
 	mm["chat_id"] = tc.chatID
 
-	body, err := json.Marshal(mm)
+	method := "/sendMessage"
+	if len(message.Media) > 0 {
+		method = "/sendMediaGroup"
+	}
+
+	var result []byte
+	if len(message.Files()) > 0 {
+		result, err = tc.callMultipart(method, mm, message.Files())
+	} else {
+		result, err = tc.call(method, mm)
+	}
+	if err != nil {
+		return nil, eris.Wrap(err, "Error on send message")
+	}
+
+	ids, err := parseTelegramMessageIDs(result)
+	if err != nil {
+		tc.logger.Warn().Msgf("Failed to parse the sent message IDs: %s", err)
+	}
+
+	return ids, nil
+}
+
+// EditMessage changes the text and the inline keyboard of a sent message.
+// For an album, the caption of the first item is changed.
+// A message without a text changes only the inline keyboard.
+func (tc *telegramChat) EditMessage(messageIDs []int, message *TelegramMessage) error {
+	mm, err := message.Map()
+	if err != nil {
+		return eris.Wrap(err, "Error on edit message")
+	}
+
+	params := map[string]interface{}{
+		"chat_id":    tc.chatID,
+		"message_id": messageIDs[0],
+	}
+	if markup, ok := mm["reply_markup"]; ok {
+		params["reply_markup"] = markup
+	}
+
+	method := "/editMessageReplyMarkup"
+	if message.Text != "" {
+		method = "/editMessageText"
+		params["text"] = message.Text
+		params["disable_web_page_preview"] = message.DisableWebPagePreview
+		if len(messageIDs) > 1 {
+			method = "/editMessageCaption"
+			params["caption"] = message.Text
+			delete(params, "text")
+			delete(params, "disable_web_page_preview")
+		}
+		if message.ParseMode != nil {
+			params["parse_mode"] = *message.ParseMode
+		}
+	}
+
+	if _, err := tc.call(method, params); err != nil {
+		return eris.Wrap(err, "Error on edit message")
+	}
+	return nil
+}
+
+func (tc *telegramChat) DeleteMessage(messageIDs []int) error {
+	for _, id := range messageIDs {
+		params := map[string]interface{}{
+			"chat_id":    tc.chatID,
+			"message_id": id,
+		}
+		if _, err := tc.call("/deleteMessage", params); err != nil {
+			return eris.Wrap(err, "Error on delete message")
+		}
+	}
+	return nil
+}
+
+func (tc *telegramChat) CreateForumTopic(name string) (int, error) {
+	body, err := tc.call("/createForumTopic", map[string]interface{}{
+		"chat_id": tc.chatID,
+		"name":    name,
+	})
 	if err != nil {
-		return eris.Wrap(err, "Error on send message")
+		return 0, eris.Wrap(err, "Error on create forum topic")
 	}
 
+	response := struct {
+		Result struct {
+			MessageThreadID int `json:"message_thread_id"` //nolint:tagliatelle
+		} `json:"result"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, eris.Wrap(err, "Error on create forum topic")
+	}
+	if response.Result.MessageThreadID == 0 {
+		return 0, eris.New("Telegram API didn't return the topic thread id")
+	}
+
+	return response.Result.MessageThreadID, nil
+}
+
+func (tc *telegramChat) call(method string, params map[string]interface{}) ([]byte, error) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return tc.doRequest(method, "application/json", body)
+}
+
+func (tc *telegramChat) callMultipart(method string, params map[string]interface{}, files map[string]*TelegramFile) ([]byte, error) {
+	body, contentType, err := telegramMultipartBody(params, files)
+	if err != nil {
+		return nil, err
+	}
+
+	return tc.doRequest(method, contentType, body)
+}
+
+func (tc *telegramChat) doRequest(method string, contentType string, body []byte) ([]byte, error) {
 	res, err := tc.httpClient.R().
-		SetHeader("Content-Type", "application/json").
+		SetHeader("Content-Type", contentType).
 		SetBody(body).
-		Post("/sendMessage")
+		Post(method)
 	if err != nil {
-		return eris.Wrap(err, "Error on send message")
+		return nil, err
 	}
 
 	if res.IsError() {
-		return eris.New(
+		return nil, eris.New(
 			fmt.Sprintf(
 				"Failed request to Telegram API: status: %d, body: %s",
 				res.StatusCode(), res.Body(),
@@ -265,5 +549,87 @@ func (tc *telegramChat) SendMessage(message *TelegramMessage) error {
 		)
 	}
 
-	return nil
+	return res.Body(), nil
+}
+
+// parseTelegramMessageIDs extracts the message IDs from the response of sendMessage or sendMediaGroup.
+func parseTelegramMessageIDs(body []byte) ([]int, error) {
+	response := struct {
+		Result json.RawMessage `json:"result"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	result := response.Result
+	if len(result) == 0 {
+		return nil, eris.New("Empty result")
+	}
+
+	type sentMessage struct {
+		MessageID int `json:"message_id"` //nolint:tagliatelle
+	}
+
+	if result[0] == '[' {
+		messages := []sentMessage{}
+		if err := json.Unmarshal(result, &messages); err != nil {
+			return nil, err
+		}
+		ids := []int{}
+		for _, m := range messages {
+			ids = append(ids, m.MessageID)
+		}
+		return ids, nil
+	}
+
+	message := sentMessage{}
+	if err := json.Unmarshal(result, &message); err != nil {
+		return nil, err
+	}
+	return []int{message.MessageID}, nil
+}
+
+// telegramMultipartBody builds a multipart request body in memory,
+// so the request could be repeated on retries.
+func telegramMultipartBody(params map[string]interface{}, files map[string]*TelegramFile) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+
+	for k, v := range params {
+		var value string
+		switch val := v.(type) {
+		case string:
+			value = val
+		case *string:
+			value = *val
+		case *int:
+			value = strconv.Itoa(*val)
+		case int:
+			value = strconv.Itoa(val)
+		default:
+			encoded, err := json.Marshal(val)
+			if err != nil {
+				return nil, "", err
+			}
+			value = string(encoded)
+		}
+		if err := writer.WriteField(k, value); err != nil {
+			return nil, "", err
+		}
+	}
+
+	for name, file := range files {
+		part, err := writer.CreateFormFile(name, file.FileName)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(file.Content); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), writer.FormDataContentType(), nil
 }
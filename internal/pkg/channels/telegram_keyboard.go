@@ -0,0 +1,105 @@
+package channels
+
+import (
+	"net/url"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/rotisserie/eris"
+)
+
+const (
+	telegramKeyboardMaxButtonsPerRow = 8
+	telegramKeyboardMaxButtons       = 100
+	telegramCallbackDataMaxBytes     = 64
+)
+
+var telegramButtonURLSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+	"tg":    true,
+}
+
+// TelegramInlineKeyboardMarkup is an inline keyboard attached to a message.
+type TelegramInlineKeyboardMarkup struct {
+	InlineKeyboard [][]*TelegramInlineKeyboardButton `json:"inlineKeyboard"`
+}
+
+// TelegramInlineKeyboardButton is an URL button or a callback button of an inline keyboard.
+type TelegramInlineKeyboardButton struct {
+	Text         string  `json:"text" mapstructure:"text"`
+	URL          *string `json:"url,omitempty" mapstructure:"url,omitempty"`
+	CallbackData *string `json:"callbackData,omitempty" mapstructure:"callback_data,omitempty"`
+}
+
+func (km *TelegramInlineKeyboardMarkup) Map() (map[string]interface{}, error) {
+	rows := [][]map[string]interface{}{}
+	for _, row := range km.InlineKeyboard {
+		buttons := []map[string]interface{}{}
+		for _, button := range row {
+			bm := map[string]interface{}{}
+			if err := mapstructure.Decode(button, &bm); err != nil {
+				return nil, err
+			}
+			buttons = append(buttons, bm)
+		}
+		rows = append(rows, buttons)
+	}
+
+	return map[string]interface{}{
+		"inline_keyboard": rows,
+	}, nil
+}
+
+func (km *TelegramInlineKeyboardMarkup) Validate() error {
+	if len(km.InlineKeyboard) == 0 {
+		return eris.Wrap(ErrInvalidMessage, "inline keyboard has no rows")
+	}
+
+	total := 0
+	for i, row := range km.InlineKeyboard {
+		if len(row) == 0 {
+			return eris.Wrapf(ErrInvalidMessage, "inline keyboard row #%d is empty", i)
+		}
+		if len(row) > telegramKeyboardMaxButtonsPerRow {
+			return eris.Wrapf(ErrInvalidMessage, "inline keyboard row #%d has more than %d buttons", i, telegramKeyboardMaxButtonsPerRow)
+		}
+		for j, button := range row {
+			if err := button.validate(); err != nil {
+				return eris.Wrapf(err, "inline keyboard button #%d in row #%d", j, i)
+			}
+		}
+		total += len(row)
+	}
+	if total > telegramKeyboardMaxButtons {
+		return eris.Wrapf(ErrInvalidMessage, "inline keyboard has more than %d buttons", telegramKeyboardMaxButtons)
+	}
+
+	return nil
+}
+
+func (b *TelegramInlineKeyboardButton) validate() error {
+	if b == nil {
+		return eris.Wrap(ErrInvalidMessage, "button is empty")
+	}
+	if b.Text == "" {
+		return eris.Wrap(ErrInvalidMessage, "button text is empty")
+	}
+	if (b.URL == nil) == (b.CallbackData == nil) {
+		return eris.Wrap(ErrInvalidMessage, "button requires either url or callback data")
+	}
+
+	if b.URL != nil {
+		u, err := url.Parse(*b.URL)
+		if err != nil || !telegramButtonURLSchemes[u.Scheme] {
+			return eris.Wrapf(ErrInvalidMessage, "invalid button url %q", *b.URL)
+		}
+	}
+
+	if b.CallbackData != nil {
+		if size := len(*b.CallbackData); size == 0 || size > telegramCallbackDataMaxBytes {
+			return eris.Wrapf(ErrInvalidMessage, "button callback data must be from 1 to %d bytes", telegramCallbackDataMaxBytes)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package channels
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/storage"
+)
+
+const (
+	telegramTopicNameMaxLength = 128
+	telegramTopicsStateBucket  = "topics"
+)
+
+var ErrTelegramInvalidTopic = eris.New("Invalid telegram topic")
+
+type telegramTopicCreator interface {
+	CreateForumTopic(name string) (int, error)
+}
+
+// telegramTopics maps the topic names from messages to the forum threads of the chat.
+// Unknown topics are created with createForumTopic and the mapping is kept in the channel state.
+type telegramTopics struct {
+	mu sync.Mutex
+
+	defaultThreadID *int
+	auto            bool
+	provider        telegramTopicCreator
+	state           storage.Storage
+}
+
+func newTelegramTopics(opts map[string]string, provider telegramTopicCreator, state storage.Storage) (*telegramTopics, error) {
+	topics := &telegramTopics{
+		provider: provider,
+		state:    state,
+	}
+
+	if val, ok := opts["topic"]; ok {
+		threadID, err := strconv.Atoi(val)
+		if err != nil || threadID <= 0 {
+			return nil, eris.Wrapf(ErrTelegramInvalidTopic, "topic: %s", val)
+		}
+		topics.defaultThreadID = &threadID
+	}
+
+	if val, ok := opts["auto_topics"]; ok {
+		auto, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, eris.Wrapf(ErrTelegramInvalidTopic, "auto_topics: %s", val)
+		}
+		topics.auto = auto
+	}
+
+	return topics, nil
+}
+
+func (t *telegramTopics) validate(message *TelegramMessage) error {
+	if message.Topic != "" && !t.auto {
+		return eris.Wrap(ErrInvalidMessage, "topics routing is disabled for the channel")
+	}
+	return nil
+}
+
+// resolve sets the thread of the message from its topic name or the default topic of the channel.
+// An explicit thread ID of the message wins.
+func (t *telegramTopics) resolve(message *TelegramMessage) error {
+	if message.MessageThreadID != nil {
+		return nil
+	}
+
+	if message.Topic == "" || !t.auto {
+		message.MessageThreadID = t.defaultThreadID
+		return nil
+	}
+
+	threadID, err := t.threadID(message.Topic)
+	if err != nil {
+		return err
+	}
+	message.MessageThreadID = &threadID
+
+	return nil
+}
+
+func (t *telegramTopics) threadID(topic string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var threadID int
+	found, err := t.state.Get(telegramTopicsStateBucket, topic, &threadID)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return threadID, nil
+	}
+
+	threadID, err = t.provider.CreateForumTopic(topic)
+	if err != nil {
+		return 0, err
+	}
+	if err := t.state.Put(telegramTopicsStateBucket, topic, threadID); err != nil {
+		return 0, err
+	}
+
+	return threadID, nil
+}
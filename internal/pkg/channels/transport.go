@@ -0,0 +1,90 @@
+package channels
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"os"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rotisserie/eris"
+)
+
+var _httpTransportOptions = []string{
+	"proxy",
+	"ca_file",
+	"cert_file",
+	"key_file",
+}
+
+var _proxySchemes = map[string]bool{
+	"http":    true,
+	"https":   true,
+	"socks5":  true,
+	"socks5h": true,
+}
+
+// TransportOptions collects the proxy and the TLS options from the query of the URL.
+func TransportOptions(u *url.URL) map[string]string {
+	return channelOptions(u, _httpTransportOptions)
+}
+
+// ConfigureTransport sets the proxy and the TLS settings of the client from the channel options.
+// Without the proxy option the client uses the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+func ConfigureTransport(client *resty.Client, opts map[string]string) error {
+	if val, ok := opts["proxy"]; ok {
+		proxyURL, err := url.Parse(val)
+		if err != nil || !_proxySchemes[proxyURL.Scheme] || proxyURL.Host == "" {
+			return eris.Wrapf(ErrInvalidChannelOption, "proxy: %s", redactURL(val))
+		}
+		client.SetProxy(proxyURL.String())
+	}
+
+	_, hasCA := opts["ca_file"]
+	_, hasCert := opts["cert_file"]
+	_, hasKey := opts["key_file"]
+	if !hasCA && !hasCert && !hasKey {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	// The CA bundle is added to the system roots.
+	if hasCA {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(opts["ca_file"])
+		if err != nil {
+			return eris.Wrapf(err, "Failed to read CA bundle %s", opts["ca_file"])
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return eris.Wrapf(ErrInvalidChannelOption, "ca_file: no certificates in %s", opts["ca_file"])
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if hasCert != hasKey {
+		return eris.Wrap(ErrInvalidChannelOption, "cert_file and key_file must be set together")
+	}
+	if hasCert {
+		cert, err := tls.LoadX509KeyPair(opts["cert_file"], opts["key_file"])
+		if err != nil {
+			return eris.Wrapf(err, "Failed to load client certificate %s", opts["cert_file"])
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	client.SetTLSClientConfig(tlsConfig)
+	return nil
+}
+
+// redactURL hides the password of the URL.
+func redactURL(val string) string {
+	u, err := url.Parse(val)
+	if err != nil {
+		return "***"
+	}
+	return u.Redacted()
+}
@@ -1,8 +1,15 @@
 package httpapi
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -13,19 +20,71 @@ import (
 	"github.com/rs/zerolog"
 
 	"github.com/unhandled-exception/tgproxy-go/internal/pkg/channels"
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/routing"
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/updates"
 )
 
-var ErrChannelNotFound = eris.New("Channel not found")
+var (
+	ErrChannelNotFound      = eris.New("Channel not found")
+	ErrEditNotSupported     = eris.New("The channel doesn't support editing of messages")
+	ErrScheduleNotSupported = eris.New("The channel doesn't support scheduled messages")
+	ErrFilesNotSupported    = eris.New("The channel doesn't support uploaded files")
+	ErrStatsNotSupported    = eris.New("The channel doesn't support statistics")
+	ErrDLQNotSupported      = eris.New("The channel doesn't support dead letters")
+	ErrStatusNotSupported   = eris.New("The channel doesn't support delivery statuses of messages")
+	ErrMessageFieldNotFound = eris.New("Multipart request requires the message field")
+	ErrReceiverNotFound     = eris.New("Update receiver not found")
+	ErrInvalidSecretToken   = eris.New("Invalid secret token")
+)
 
-const httpAPIRequestTimeout = 5 * time.Second
+const (
+	httpAPIRequestTimeout = 5 * time.Second
+	maxUploadMemory       = 32 << 20
+	multipartMessageField = "message"
+	maxUpdateSize         = 1 << 20
+	secretTokenHeader     = "X-Telegram-Bot-Api-Secret-Token"
+)
 
 type HTTPAPI struct {
-	router      *chi.Mux
-	logger      *zerolog.Logger
-	channelsMap map[string]channels.MessageChannelInterface
+	router       *chi.Mux
+	logger       *zerolog.Logger
+	channelsMap  map[string]channels.MessageChannelInterface
+	groupsMap    map[string]*channels.ChannelGroup
+	routingRules *routing.Router
+	receiversMap map[string]*updates.Receiver
+}
+
+// Option configures optional features of the API.
+type Option func(api *HTTPAPI)
+
+// WithChannelGroups makes the groups addressable like channels.
+func WithChannelGroups(groups []*channels.ChannelGroup) Option {
+	return func(api *HTTPAPI) {
+		for _, g := range groups {
+			api.groupsMap[g.Name()] = g
+		}
+	}
+}
+
+// WithReceivers enables the webhook endpoints of the update receivers in the webhook mode.
+func WithReceivers(receivers []*updates.Receiver) Option {
+	return func(api *HTTPAPI) {
+		for _, r := range receivers {
+			if r.Mode() == updates.ModeWebhook {
+				api.receiversMap[r.Name()] = r
+			}
+		}
+	}
+}
+
+// WithRouter enables the label-based routing of messages.
+func WithRouter(router *routing.Router) Option {
+	return func(api *HTTPAPI) {
+		api.routingRules = router
+	}
 }
 
-func NewHTTPAPI(messagesChannels []channels.MessageChannelInterface, logger *zerolog.Logger) *HTTPAPI {
+func NewHTTPAPI(messagesChannels []channels.MessageChannelInterface, logger *zerolog.Logger, opts ...Option) *HTTPAPI {
 	router := chi.NewRouter()
 
 	channelsMap := map[string]channels.MessageChannelInterface{}
@@ -34,9 +93,14 @@ func NewHTTPAPI(messagesChannels []channels.MessageChannelInterface, logger *zer
 	}
 
 	api := &HTTPAPI{
-		router:      router,
-		logger:      logger,
-		channelsMap: channelsMap,
+		router:       router,
+		logger:       logger,
+		channelsMap:  channelsMap,
+		groupsMap:    map[string]*channels.ChannelGroup{},
+		receiversMap: map[string]*updates.Receiver{},
+	}
+	for _, opt := range opts {
+		opt(api)
 	}
 
 	router.Use(httplog.RequestLogger(*logger))
@@ -46,7 +110,22 @@ func NewHTTPAPI(messagesChannels []channels.MessageChannelInterface, logger *zer
 
 	router.Get("/ping", api.onPing)
 	router.Get("/", api.onIndex)
+	if api.routingRules != nil {
+		router.Post("/route", api.onRoute)
+		router.Post("/route/dry-run", api.onRouteDryRun)
+		router.Post("/route/reload", api.onRouteReload)
+	}
+	if len(api.receiversMap) > 0 {
+		router.Post("/updates/{receiverName}", api.onUpdate)
+	}
+	router.Get("/{channelName}", api.onStats)
 	router.Post("/{channelName}", api.onSend)
+	router.Get("/{channelName}/messages/{messageID}", api.onMessageStatus)
+	router.Patch("/{channelName}/messages/{messageID}", api.onEdit)
+	router.Delete("/{channelName}/messages/{messageID}", api.onDelete)
+	router.Get("/{channelName}/scheduled", api.onScheduled)
+	router.Delete("/{channelName}/scheduled/{messageID}", api.onCancelScheduled)
+	router.Get("/{channelName}/dead-letters", api.onDeadLetters)
 
 	return api
 }
@@ -94,30 +173,437 @@ func (api *HTTPAPI) onIndex(w http.ResponseWriter, r *http.Request) {
 		channels[k] = fmt.Sprint(v)
 	}
 
-	api.renderSuccess(w, r, map[string]interface{}{
+	data := map[string]interface{}{
 		"channels": channels,
-	}, http.StatusOK)
+	}
+	if len(api.groupsMap) > 0 {
+		groups := map[string][]string{}
+		for k, v := range api.groupsMap {
+			groups[k] = v.MemberNames()
+		}
+		data["groups"] = groups
+	}
+
+	api.renderSuccess(w, r, data, http.StatusOK)
 }
 
 func (api *HTTPAPI) onSend(w http.ResponseWriter, r *http.Request) {
-	ch, err := api.GetChannel(chi.URLParam(r, "channelName"))
+	name := chi.URLParam(r, "channelName")
+	if group, ok := api.groupsMap[name]; ok {
+		api.sendToGroup(w, r, group)
+		return
+	}
+
+	ch, err := api.GetChannel(name)
 	if err != nil {
 		api.renderError(w, r, err, http.StatusNotFound)
 		return
 	}
 
+	decoder, err := newMessageDecoder(r)
+	if err != nil {
+		api.renderError(w, r, err, http.StatusBadRequest)
+		return
+	}
 	message := ch.MessageContainer()
-	if err := render.DecodeJSON(r.Body, message); err != nil {
+	if err := decoder.Decode(message); err != nil {
+		api.renderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := ch.Enqueue(message)
+	if err != nil {
+		setRetryAfter(w, ch, err)
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	api.renderSuccess(w, r, map[string]interface{}{
+		"id": id,
+	}, http.StatusCreated)
+}
+
+func (api *HTTPAPI) onEdit(w http.ResponseWriter, r *http.Request) {
+	editor, err := api.getMessageEditor(chi.URLParam(r, "channelName"))
+	if err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	decoder, err := newMessageDecoder(r)
+	if err != nil {
+		api.renderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	message := editor.MessageContainer()
+	if err := decoder.Decode(message); err != nil {
+		api.renderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := editor.EnqueueEdit(chi.URLParam(r, "messageID"), message); err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	api.renderSuccess(w, r, nil, http.StatusAccepted)
+}
+
+func (api *HTTPAPI) onDelete(w http.ResponseWriter, r *http.Request) {
+	editor, err := api.getMessageEditor(chi.URLParam(r, "channelName"))
+	if err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	if err := editor.EnqueueDelete(chi.URLParam(r, "messageID")); err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	api.renderSuccess(w, r, nil, http.StatusAccepted)
+}
+
+func (api *HTTPAPI) onScheduled(w http.ResponseWriter, r *http.Request) {
+	scheduler, err := api.getMessageScheduler(chi.URLParam(r, "channelName"))
+	if err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	api.renderSuccess(w, r, map[string]interface{}{
+		"scheduled": scheduler.Scheduled(),
+	}, http.StatusOK)
+}
+
+func (api *HTTPAPI) onCancelScheduled(w http.ResponseWriter, r *http.Request) {
+	scheduler, err := api.getMessageScheduler(chi.URLParam(r, "channelName"))
+	if err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	if err := scheduler.CancelScheduled(chi.URLParam(r, "messageID")); err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	api.renderSuccess(w, r, nil, http.StatusOK)
+}
+
+func (api *HTTPAPI) onStats(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "channelName")
+	ch, err := api.GetChannel(name)
+	if err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	counter, ok := ch.(channels.MessageStatsInterface)
+	if !ok {
+		api.renderError(w, r, eris.Wrap(ErrStatsNotSupported, name), http.StatusNotImplemented)
+		return
+	}
+
+	api.renderSuccess(w, r, map[string]interface{}{
+		"channel": ch.String(),
+		"stats":   counter.Stats(),
+	}, http.StatusOK)
+}
+
+func (api *HTTPAPI) onDeadLetters(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "channelName")
+	ch, err := api.GetChannel(name)
+	if err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	dlq, ok := ch.(channels.DeadLettersInterface)
+	if !ok {
+		api.renderError(w, r, eris.Wrap(ErrDLQNotSupported, name), http.StatusNotImplemented)
+		return
+	}
+
+	deadLetters, err := dlq.DeadLetters()
+	if err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	api.renderSuccess(w, r, map[string]interface{}{
+		"deadLetters": deadLetters,
+	}, http.StatusOK)
+}
+
+func (api *HTTPAPI) onMessageStatus(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "channelName")
+	ch, err := api.GetChannel(name)
+	if err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	tracker, ok := ch.(channels.MessageStatusInterface)
+	if !ok {
+		api.renderError(w, r, eris.Wrap(ErrStatusNotSupported, name), http.StatusNotImplemented)
+		return
+	}
+
+	status, err := tracker.MessageStatus(chi.URLParam(r, "messageID"))
+	if err != nil {
+		api.renderError(w, r, err, errorStatusCode(err))
+		return
+	}
+
+	api.renderSuccess(w, r, map[string]interface{}{
+		"message": status,
+	}, http.StatusOK)
+}
+
+// onUpdate receives the update from the Telegram webhook.
+func (api *HTTPAPI) onUpdate(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "receiverName")
+	receiver, ok := api.receiversMap[name]
+	if !ok {
+		api.renderError(w, r, eris.Wrap(ErrReceiverNotFound, name), http.StatusNotFound)
+		return
+	}
+
+	if !receiver.VerifySecret(r.Header.Get(secretTokenHeader)) {
+		api.renderError(w, r, eris.Wrap(ErrInvalidSecretToken, name), http.StatusUnauthorized)
+		return
+	}
+
+	update, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUpdateSize))
+	if err != nil {
+		api.renderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := receiver.HandleWebhook(update); err != nil {
+		statusCode := http.StatusServiceUnavailable
+		if eris.Is(err, updates.ErrInvalidUpdate) {
+			statusCode = http.StatusBadRequest
+		}
+		api.renderError(w, r, err, statusCode)
+		return
+	}
+
+	api.renderSuccess(w, r, map[string]interface{}{}, http.StatusOK)
+}
+
+func (api *HTTPAPI) getMessageScheduler(name string) (channels.MessageSchedulerInterface, error) {
+	ch, err := api.GetChannel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduler, ok := ch.(channels.MessageSchedulerInterface)
+	if !ok {
+		return nil, eris.Wrap(ErrScheduleNotSupported, name)
+	}
+	return scheduler, nil
+}
+
+type editableChannel interface {
+	channels.MessageChannelInterface
+	channels.MessageEditorInterface
+}
+
+func (api *HTTPAPI) getMessageEditor(name string) (editableChannel, error) {
+	ch, err := api.GetChannel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	editor, ok := ch.(editableChannel)
+	if !ok {
+		return nil, eris.Wrap(ErrEditNotSupported, name)
+	}
+	return editor, nil
+}
+
+// sendToGroup enqueues a copy of the message on each member of the group
+// and reports the results per member.
+func (api *HTTPAPI) sendToGroup(w http.ResponseWriter, r *http.Request, group *channels.ChannelGroup) {
+	decoder, err := newMessageDecoder(r)
+	if err != nil {
 		api.renderError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	if err := ch.Enqueue(message); err != nil {
-		api.renderError(w, r, err, http.StatusServiceUnavailable)
+	results := group.Enqueue(decoder.newMessage)
+	api.renderFanOutResults(w, r, results)
+}
+
+// renderFanOutResults renders the results of enqueueing a message on several channels.
+// Partial failures are reported with 207 Multi-Status.
+func (api *HTTPAPI) renderFanOutResults(w http.ResponseWriter, r *http.Request, results []*channels.GroupMemberResult) {
+	failed := 0
+	var firstErr error
+	var retryAfter time.Duration
+	members := map[string]interface{}{}
+	for _, res := range results {
+		if res.Err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = res.Err
+			}
+			retryAfter = max(retryAfter, res.RetryAfter)
+			members[res.Channel] = map[string]string{
+				"status":  "error",
+				"message": res.Err.Error(),
+			}
+			continue
+		}
+		members[res.Channel] = map[string]string{
+			"status": "success",
+			"id":     res.ID,
+		}
+	}
+
+	status := "success"
+	httpStatusCode := http.StatusCreated
+	switch {
+	case failed > 0 && failed == len(results):
+		status = "error"
+		httpStatusCode = errorStatusCode(firstErr)
+	case failed > 0:
+		status = "partial"
+		httpStatusCode = http.StatusMultiStatus
+	}
+	if retryAfter > 0 {
+		writeRetryAfter(w, retryAfter)
+	}
+
+	render.Status(r, httpStatusCode)
+	render.JSON(w, r, map[string]interface{}{
+		"status":  status,
+		"results": members,
+	})
+}
+
+// messageDecoder decodes message containers from a JSON body or from a multipart form
+// with the JSON message in the "message" field and the uploaded files in the other fields.
+// The request is read once, so the decoder may be used for several containers.
+type messageDecoder struct {
+	body []byte
+	form *multipart.Form
+}
+
+func newMessageDecoder(r *http.Request) (*messageDecoder, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !strings.EqualFold(mediaType, "multipart/form-data") {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &messageDecoder{body: body}, nil
+	}
+
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		return nil, err
+	}
+
+	body, ok := r.MultipartForm.Value[multipartMessageField]
+	if !ok || len(body) == 0 {
+		return nil, ErrMessageFieldNotFound
+	}
+
+	return &messageDecoder{
+		body: []byte(body[0]),
+		form: r.MultipartForm,
+	}, nil
+}
+
+// newMessage decodes a new message container of the channel.
+func (d *messageDecoder) newMessage(ch channels.MessageChannelInterface) (interface{}, error) {
+	message := ch.MessageContainer()
+	if err := d.Decode(message); err != nil {
+		return nil, eris.Wrap(channels.ErrInvalidMessage, err.Error())
+	}
+	return message, nil
+}
+
+// Decode decodes the message and attaches the uploaded files to it.
+func (d *messageDecoder) Decode(message interface{}) error {
+	if err := d.DecodeJSON(message); err != nil {
+		return err
+	}
+	if d.form == nil {
+		return nil
+	}
+	return attachUploadedFiles(d.form, message)
+}
+
+// DecodeJSON decodes the JSON part of the request without files.
+func (d *messageDecoder) DecodeJSON(v interface{}) error {
+	return render.DecodeJSON(bytes.NewReader(d.body), v)
+}
+
+func attachUploadedFiles(form *multipart.Form, message interface{}) error {
+	if len(form.File) == 0 {
+		return nil
+	}
+
+	container, ok := message.(channels.FilesContainerInterface)
+	if !ok {
+		return ErrFilesNotSupported
+	}
+	for name, headers := range form.File {
+		for _, header := range headers {
+			content, err := readUploadedFile(header)
+			if err != nil {
+				return err
+			}
+			if err := container.AttachFile(name, header.Filename, content); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readUploadedFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// setRetryAfter tells the client when to retry the message rejected by the full channel.
+func setRetryAfter(w http.ResponseWriter, ch channels.MessageChannelInterface, err error) {
+	bp, ok := ch.(channels.BackpressureInterface)
+	if !ok || !eris.Is(err, channels.ErrChannelIsFull) {
 		return
 	}
+	writeRetryAfter(w, bp.RetryAfter())
+}
 
-	api.renderSuccess(w, r, nil, http.StatusCreated)
+func writeRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+func errorStatusCode(err error) int {
+	switch {
+	case eris.Is(err, channels.ErrInvalidMessage), eris.Is(err, channels.ErrInvalidMessageType):
+		return http.StatusBadRequest
+	case eris.Is(err, ErrChannelNotFound), eris.Is(err, channels.ErrMessageNotFound):
+		return http.StatusNotFound
+	case eris.Is(err, ErrNoRoute):
+		return http.StatusUnprocessableEntity
+	case eris.Is(err, ErrEditNotSupported), eris.Is(err, ErrScheduleNotSupported),
+		eris.Is(err, ErrStatsNotSupported), eris.Is(err, ErrDLQNotSupported), eris.Is(err, ErrStatusNotSupported),
+		eris.Is(err, channels.ErrOperationNotSupported):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusServiceUnavailable
+	}
 }
 
 func (api *HTTPAPI) renderError(w http.ResponseWriter, r *http.Request, err error, httpStatusCode int) {
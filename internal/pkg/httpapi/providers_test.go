@@ -0,0 +1,628 @@
+//go:build !race
+
+package httpapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/channels"
+)
+
+// recordedRequests collects the requests received by a stand-in of the service.
+type recordedRequests struct {
+	mu       sync.Mutex
+	requests []*mockedRequest
+}
+
+func (rr *recordedRequests) add(req *http.Request) *mockedRequest {
+	mr, _ := newMockedRequest(req)
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.requests = append(rr.requests, mr)
+	return mr
+}
+
+func (rr *recordedRequests) list() []*mockedRequest {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return append([]*mockedRequest{}, rr.requests...)
+}
+
+func (ts *httpapiTestSuite) useTestServer(channelName string, server *httptest.Server) {
+	ch, err := ts.sut.GetChannel(channelName)
+	ts.Require().NoError(err)
+	ch.Provider().HTTPClient().Transport = server.Client().Transport
+}
+
+func (ts *httpapiTestSuite) TestSlackWebhook() {
+	recorded := &recordedRequests{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorded.add(r)
+		if len(recorded.list()) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	ts.sut = ts.newSUT([]string{"slack://T000:B000:XXXX@" + host + "/alerts?username=tgp"}, nil)
+	ts.useTestServer("alerts", server)
+
+	for _, message := range []map[string]interface{}{
+		{"text": `<b>Disk</b> is <i>full</i> on <a href="https://db.local">db-1</a> &amp; db-2`, "parseMode": "HTML"},
+		{"text": "[Runbook](https://wiki.local/disk_full) for ||db-1||, `df -h > out`", "parseMode": "MarkdownV2", "disableWebPagePreview": 1},
+	} {
+		resp, body := ts.sutRequest(http.MethodPost, _testAPIURL+"/alerts", message)
+		resp.Body.Close()
+		ts.Require().Equal(http.StatusCreated, resp.StatusCode, body)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	requests := recorded.list()
+	ts.Require().Len(requests, 3)
+
+	payloads := []map[string]interface{}{}
+	for _, req := range requests {
+		ts.Equal("/services/T000/B000/XXXX", req.URL)
+		payload := map[string]interface{}{}
+		ts.Require().NoError(json.Unmarshal([]byte(req.Body), &payload))
+		payloads = append(payloads, payload)
+	}
+	ts.Equal(payloads[0], payloads[1], "the rate limited message is retried")
+	ts.Equal(
+		[]map[string]interface{}{
+			{"text": "*Disk* is _full_ on <https://db.local|db-1> &amp; db-2", "mrkdwn": true, "unfurl_links": true, "unfurl_media": true, "username": "tgp"},
+			{"text": "<https://wiki.local/disk_full|Runbook> for db-1, `df -h &gt; out`", "mrkdwn": true, "unfurl_links": false, "unfurl_media": false, "username": "tgp"},
+		},
+		payloads[1:],
+	)
+
+	resp, _ := ts.sutRequest(http.MethodPost, _testAPIURL+"/alerts", map[string]interface{}{
+		"text":  "Album",
+		"media": []map[string]interface{}{{"type": "photo", "media": "https://a"}, {"type": "photo", "media": "https://b"}},
+	})
+	resp.Body.Close()
+	ts.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = ts.sutRequest(http.MethodDelete, _testAPIURL+"/alerts/messages/any", nil)
+	resp.Body.Close()
+	ts.Equal(http.StatusNotImplemented, resp.StatusCode)
+}
+
+func (ts *httpapiTestSuite) TestDiscordWebhook() {
+	recorded := &recordedRequests{}
+	patched := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := len(recorded.list())
+		recorded.add(r)
+		w.Header().Set(_testContentTypeHeader, _testApplicationJSONCT)
+		switch {
+		case r.Method == http.MethodPost && n == 0:
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "0.3")
+		case r.Method == http.MethodPatch && !patched:
+			patched = true
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.1, "global": false}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": "10` + strconv.Itoa(n) + `"}`))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	ts.sut = ts.newSUT([]string{"discord://123:token@" + host + "/announcements?username=tgp"}, nil)
+	ts.useTestServer("announcements", server)
+
+	longText := strings.Repeat("Line of the release notes\n", 100)
+	resp, body := ts.sutRequest(http.MethodPost, _testAPIURL+"/announcements", map[string]interface{}{
+		"text":                  longText,
+		"key":                   "release",
+		"disableWebPagePreview": 1,
+	})
+	resp.Body.Close()
+	ts.Require().Equal(http.StatusCreated, resp.StatusCode, body)
+
+	resp, body = ts.sutRequest(http.MethodPatch, _testAPIURL+"/announcements/messages/release", map[string]interface{}{
+		"text":      "<b>Release</b> <i>1.2</i> is out: <a href=\"https://example.com\">notes</a>, <code>go get</code> *now*",
+		"parseMode": "HTML",
+	})
+	resp.Body.Close()
+	ts.Require().Equal(http.StatusAccepted, resp.StatusCode, body)
+
+	time.Sleep(800 * time.Millisecond)
+	requests := recorded.list()
+	ts.Require().Len(requests, 5)
+
+	type call struct {
+		Method  string
+		URL     string
+		Payload map[string]interface{}
+	}
+	calls := []call{}
+	for _, req := range requests {
+		payload := map[string]interface{}{}
+		if req.Body != "" {
+			ts.Require().NoError(json.Unmarshal([]byte(req.Body), &payload))
+		}
+		calls = append(calls, call{req.Method, req.URL, payload})
+	}
+
+	ts.Equal(http.MethodPost, calls[0].Method)
+	ts.Equal("/api/webhooks/123/token?wait=true", calls[0].URL)
+	ts.Equal(4.0, calls[0].Payload["flags"])
+	ts.Equal("tgp", calls[0].Payload["username"])
+	ts.LessOrEqual(len([]rune(calls[0].Payload["content"].(string))), 2000)
+	ts.Equal(longText, calls[0].Payload["content"].(string)+"\n"+calls[1].Payload["content"].(string))
+
+	edited := map[string]interface{}{
+		"content": "**Release** *1.2* is out: [notes](https://example.com), `go get` \\*now\\*",
+		"flags":   0.0,
+	}
+	ts.Equal(
+		[]call{
+			{http.MethodPatch, "/api/webhooks/123/token/messages/100", edited},
+			{http.MethodPatch, "/api/webhooks/123/token/messages/100", edited},
+			{http.MethodDelete, "/api/webhooks/123/token/messages/101", map[string]interface{}{}},
+		},
+		calls[2:],
+	)
+}
+
+func (ts *httpapiTestSuite) TestDiscordPartialSend() {
+	recorded := &recordedRequests{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := len(recorded.list())
+		recorded.add(r)
+		w.Header().Set(_testContentTypeHeader, _testApplicationJSONCT)
+		if r.Method == http.MethodPost && n == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message": "Invalid Form Body"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": "10` + strconv.Itoa(n) + `"}`))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	ts.sut = ts.newSUT([]string{"discord://123:token@" + host + "/announcements"}, nil)
+	ts.useTestServer("announcements", server)
+
+	// The code block is longer than a Discord message.
+	code := strings.Repeat("fmt.Println(\"Line of the release notes\")\n", 100)
+	resp, body := ts.sutRequest(http.MethodPost, _testAPIURL+"/announcements", map[string]interface{}{
+		"text": "Changes:\n```go\n" + code + "```",
+		"key":  "release",
+	})
+	resp.Body.Close()
+	ts.Require().Equal(http.StatusCreated, resp.StatusCode, body)
+	time.Sleep(200 * time.Millisecond)
+
+	// The parts sent before the failure are deleted with the message.
+	resp, body = ts.sutRequest(http.MethodDelete, _testAPIURL+"/announcements/messages/release", nil)
+	resp.Body.Close()
+	ts.Require().Equal(http.StatusAccepted, resp.StatusCode, body)
+	time.Sleep(200 * time.Millisecond)
+
+	requests := recorded.list()
+	ts.Require().Len(requests, 5)
+	for _, req := range requests[:3] {
+		ts.Equal(http.MethodPost, req.Method)
+		payload := map[string]interface{}{}
+		ts.Require().NoError(json.Unmarshal([]byte(req.Body), &payload))
+		content := payload["content"].(string)
+		ts.LessOrEqual(len([]rune(content)), 2000)
+		ts.Equal(0, strings.Count(content, "```")%2, "every part has the closed code block")
+		ts.NotContains(content, "fmt.Println(\"Line of the release notes\")fmt")
+	}
+	ts.Equal(http.MethodDelete, requests[3].Method)
+	ts.Equal("/api/webhooks/123/token/messages/100", requests[3].URL)
+	ts.Equal("/api/webhooks/123/token/messages/101", requests[4].URL)
+}
+
+// smtpEnvelope is an email received by the SMTP stand-in.
+type smtpEnvelope struct {
+	Auth string
+	From string
+	To   []string
+	Data string
+}
+
+// startSMTPServer starts a minimal in-process SMTP server without TLS.
+// The rcptReply returns the reply to the RCPT command, nil accepts all recipients.
+func (ts *httpapiTestSuite) startSMTPServer(rcptReply func(rcpt string) string) (string, func() []*smtpEnvelope) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	ts.Require().NoError(err)
+	ts.T().Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	envelopes := []*smtpEnvelope{}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				tp := textproto.NewConn(conn)
+				_ = tp.PrintfLine("220 localhost ESMTP")
+
+				envelope := &smtpEnvelope{}
+				for {
+					line, err := tp.ReadLine()
+					if err != nil {
+						return
+					}
+					cmd, arg, _ := strings.Cut(line, " ")
+					switch strings.ToUpper(cmd) {
+					case "EHLO", "HELO":
+						_ = tp.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+					case "AUTH":
+						envelope.Auth = arg
+						_ = tp.PrintfLine("235 Authenticated")
+					case "MAIL":
+						envelope.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+						_ = tp.PrintfLine("250 OK")
+					case "RCPT":
+						rcpt := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+						if rcptReply != nil {
+							if reply := rcptReply(rcpt); reply != "" {
+								_ = tp.PrintfLine("%s", reply)
+								continue
+							}
+						}
+						envelope.To = append(envelope.To, rcpt)
+						_ = tp.PrintfLine("250 OK")
+					case "DATA":
+						_ = tp.PrintfLine("354 Go ahead")
+						data, err := tp.ReadDotBytes()
+						if err != nil {
+							return
+						}
+						envelope.Data = string(data)
+						mu.Lock()
+						envelopes = append(envelopes, envelope)
+						mu.Unlock()
+						envelope = &smtpEnvelope{Auth: envelope.Auth}
+						_ = tp.PrintfLine("250 Queued")
+					case "QUIT":
+						_ = tp.PrintfLine("221 Bye")
+						return
+					default:
+						_ = tp.PrintfLine("502 Not implemented")
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String(), func() []*smtpEnvelope {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*smtpEnvelope{}, envelopes...)
+	}
+}
+
+func (ts *httpapiTestSuite) TestSMTPChannel() {
+	addr, received := ts.startSMTPServer(nil)
+	ts.sut = ts.newSUT([]string{
+		"smtp://user:secret@" + addr + "/stakeholders?tls=none&batch=2&from=tgp@example.com&to=a@example.com,b@example.com,c@example.com",
+	}, nil)
+
+	resp, body := ts.sutRequest(http.MethodPost, _testAPIURL+"/stakeholders", map[string]interface{}{
+		"text":      "<b>Release 1.2</b> is out\nSee <a href=\"https://example.com/notes\">notes</a>",
+		"parseMode": "HTML",
+	})
+	resp.Body.Close()
+	ts.Require().Equal(http.StatusCreated, resp.StatusCode, body)
+
+	time.Sleep(300 * time.Millisecond)
+	envelopes := received()
+	ts.Require().Len(envelopes, 2)
+	ts.Equal([]string{"a@example.com", "b@example.com"}, envelopes[0].To)
+	ts.Equal([]string{"c@example.com"}, envelopes[1].To)
+
+	for _, envelope := range envelopes {
+		ts.Equal("tgp@example.com", envelope.From)
+		ts.Equal("PLAIN AHVzZXIAc2VjcmV0", envelope.Auth)
+
+		msg, err := mail.ReadMessage(strings.NewReader(envelope.Data))
+		ts.Require().NoError(err)
+		ts.Equal("Release 1.2 is out", msg.Header.Get("Subject"))
+		ts.Equal(strings.Join(envelope.To, ", "), msg.Header.Get("To"))
+
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get(_testContentTypeHeader))
+		ts.Require().NoError(err)
+		ts.Equal("multipart/alternative", mediaType)
+
+		parts := map[string]string{}
+		reader := multipart.NewReader(msg.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			content, _ := io.ReadAll(part)
+			parts[strings.Split(part.Header.Get(_testContentTypeHeader), ";")[0]] = string(content)
+		}
+		ts.Equal("Release 1.2 is out\nSee notes (https://example.com/notes)", parts["text/plain"])
+		ts.Equal(
+			`<!DOCTYPE html><html><body><b>Release 1.2</b> is out<br>`+"\n"+`See <a href="https://example.com/notes">notes</a></body></html>`,
+			parts["text/html"],
+		)
+	}
+
+	logger := zerolog.New(io.Discard)
+	_, err := channels.BuildChannelsFromURLS([]string{"smtp://" + addr + "/invalid?from=tgp@example.com"}, &logger)
+	ts.Error(err)
+}
+
+func (ts *httpapiTestSuite) TestSMTPFailedBatch() {
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	addr, received := ts.startSMTPServer(func(rcpt string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[rcpt]++
+		switch {
+		case rcpt == "b@example.com" && attempts[rcpt] == 1:
+			return "451 Try again later"
+		case rcpt == "c@example.com":
+			return "550 No such user"
+		}
+		return ""
+	})
+	ts.sut = ts.newSUT([]string{
+		"smtp://" + addr + "/stakeholders?tls=none&batch=1&from=tgp@example.com&to=a@example.com,b@example.com,c@example.com",
+	}, nil)
+
+	resp, body := ts.sutRequest(http.MethodPost, _testAPIURL+"/stakeholders", map[string]interface{}{
+		"text": "Release 1.2 is out",
+		"key":  "release",
+	})
+	resp.Body.Close()
+	ts.Require().Equal(http.StatusCreated, resp.StatusCode, body)
+	time.Sleep(time.Second)
+
+	// The failed batch is retried alone, the permanent failure isn't retried.
+	recipients := []string{}
+	for _, envelope := range received() {
+		recipients = append(recipients, envelope.To...)
+	}
+	ts.Equal([]string{"a@example.com", "b@example.com"}, recipients)
+	mu.Lock()
+	ts.Equal(map[string]int{"a@example.com": 1, "b@example.com": 2, "c@example.com": 1}, attempts)
+	mu.Unlock()
+
+	resp, body = ts.sutRequest(http.MethodGet, _testAPIURL+"/stakeholders/messages/release", nil)
+	resp.Body.Close()
+	ts.Contains(body, `"status":"failed"`)
+}
+
+func (ts *httpapiTestSuite) TestWebhookChannel() {
+	events := &recordedRequests{}
+	incidents := &recordedRequests{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/incidents" {
+			incidents.add(r)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		events.add(r)
+		if len(events.list()) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	ts.sut = ts.newSUT([]string{
+		"http+webhook://user:secret@" + host + "/api/events?name=events&source=tgp",
+		"http+webhook://" + host + "/incidents?name=incidents&method=put&success=202&header=X-Token:abc&body=" +
+			url.QueryEscape(`{"summary": {{json (plain .)}}, "priority": {{json .Priority}}}`),
+	}, nil)
+	ts.useTestServer("events", server)
+	ts.useTestServer("incidents", server)
+
+	resp, body := ts.sutRequest(http.MethodGet, _testAPIURL+"/", nil)
+	resp.Body.Close()
+	ts.JSONEq(
+		`{
+			"status": "success",
+			"channels": {
+				"events": "http+webhook://user:***@`+host+`/api/events?name=events",
+				"incidents": "http+webhook://`+host+`/incidents?body=`+`{\"summary\": {{json (plain .)}}, \"priority\": {{json .Priority}}}`+`&header=***&method=put&name=incidents&success=202"
+			}
+		}`,
+		body,
+	)
+
+	for _, request := range []struct {
+		channel string
+		message map[string]interface{}
+	}{
+		{"events", map[string]interface{}{"text": "Deploy started", "key": "deploy-1"}},
+		{"incidents", map[string]interface{}{"text": "<b>Database</b> is down", "parseMode": "HTML", "priority": "critical"}},
+	} {
+		resp, body := ts.sutRequest(http.MethodPost, _testAPIURL+"/"+request.channel, request.message)
+		resp.Body.Close()
+		ts.Require().Equal(http.StatusCreated, resp.StatusCode, body)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	requests := events.list()
+	ts.Require().Len(requests, 2)
+
+	// The first request is retried after the server error.
+	for _, req := range requests {
+		ts.Equal(http.MethodPost, req.Method)
+		ts.Equal("/api/events?source=tgp", req.URL)
+		ts.Equal("Basic dXNlcjpzZWNyZXQ=", req.Header.Get("Authorization"))
+		ts.JSONEq(`{"text": "Deploy started", "key": "deploy-1", "disableWebPagePreview": 0, "disableNotifications": 0}`, req.Body)
+	}
+
+	requests = incidents.list()
+	ts.Require().Len(requests, 1)
+	ts.Equal(http.MethodPut, requests[0].Method)
+	ts.Equal("/incidents", requests[0].URL)
+	ts.Equal("abc", requests[0].Header.Get("X-Token"))
+	ts.JSONEq(`{"summary": "Database is down", "priority": "critical"}`, requests[0].Body)
+
+	logger := zerolog.New(io.Discard)
+	_, err := channels.BuildChannelsFromURLS([]string{"webhook://example.com/hook"}, &logger)
+	ts.Error(err)
+}
+
+func (ts *httpapiTestSuite) TestMatrixChannel() {
+	recorded := &recordedRequests{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorded.add(r)
+		w.Header().Set("Content-Type", "application/json")
+		if len(recorded.list()) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"errcode": "M_LIMIT_EXCEEDED", "error": "Too many requests", "retry_after_ms": 200}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"event_id": "$event` + strconv.Itoa(len(recorded.list())) + `"}`))
+	}))
+	defer server.Close()
+
+	ts.sut = ts.newSUT([]string{
+		"matrix://syt_token@matrix.example.com/security?room=!ops:example.com",
+	}, nil)
+	ts.useTestServer("security", server)
+
+	ids := []string{}
+	for _, message := range []map[string]interface{}{
+		{"text": "<b>Login</b> from a new location", "parseMode": "HTML"},
+		{"text": "Nightly scan finished", "disableNotifications": 1},
+	} {
+		resp, body := ts.sutRequest(http.MethodPost, _testAPIURL+"/security", message)
+		resp.Body.Close()
+		ts.Require().Equal(http.StatusCreated, resp.StatusCode, body)
+		result := map[string]string{}
+		ts.Require().NoError(json.Unmarshal([]byte(body), &result))
+		ids = append(ids, result["id"])
+	}
+
+	time.Sleep(700 * time.Millisecond)
+	requests := recorded.list()
+	ts.Require().Len(requests, 3)
+
+	pathPrefix := "/_matrix/client/v3/rooms/%21ops:example.com/send/m.room.message/"
+	for _, req := range requests {
+		ts.Equal(http.MethodPut, req.Method)
+		ts.True(strings.HasPrefix(req.URL, pathPrefix), req.URL)
+		ts.Equal("Bearer syt_token", req.Header.Get("Authorization"))
+	}
+
+	// The transaction ID is the message ID, the rate limited request is retried with the same transaction ID.
+	ts.Equal(pathPrefix+ids[0], requests[0].URL)
+	ts.Equal(pathPrefix+ids[0], requests[1].URL)
+	ts.Equal(pathPrefix+ids[1], requests[2].URL)
+
+	ts.JSONEq(`{
+		"msgtype": "m.text",
+		"body": "Login from a new location",
+		"format": "org.matrix.custom.html",
+		"formatted_body": "<b>Login</b> from a new location"
+	}`, requests[1].Body)
+	ts.JSONEq(`{"msgtype": "m.notice", "body": "Nightly scan finished"}`, requests[2].Body)
+
+	resp, body := ts.sutRequest(http.MethodGet, _testAPIURL+"/security", nil)
+	resp.Body.Close()
+	ts.Contains(body, `"sent":2`)
+
+	// The access token isn't shown in the list of channels.
+	resp, body = ts.sutRequest(http.MethodGet, _testAPIURL+"/", nil)
+	resp.Body.Close()
+	ts.Contains(body, "matrix://***@matrix.example.com/security?room=!ops:example.com")
+	ts.NotContains(body, "syt_token")
+
+	logger := zerolog.New(io.Discard)
+	_, err := channels.BuildChannelsFromURLS([]string{"matrix://syt_token@matrix.example.com/security"}, &logger)
+	ts.ErrorIs(err, channels.ErrMatrixInvalidOptions)
+}
+
+func (ts *httpapiTestSuite) TestFileChannel() {
+	path := filepath.Join(ts.T().TempDir(), "messages.jsonl")
+	ts.sut = ts.newSUT([]string{
+		"file://" + path + "?name=dev&max_size=1&max_files=5",
+		"stdout:///console",
+	}, nil)
+
+	resp, body := ts.sutRequest(http.MethodGet, _testAPIURL+"/", nil)
+	resp.Body.Close()
+	ts.JSONEq(
+		`{
+			"status": "success",
+			"channels": {
+				"dev": "file://`+path+`?max_files=5&max_size=1&name=dev",
+				"console": "stdout:///console"
+			}
+		}`,
+		body,
+	)
+
+	for _, request := range []struct {
+		method  string
+		url     string
+		message map[string]interface{}
+	}{
+		{http.MethodPost, "/dev", map[string]interface{}{"text": "Build started", "key": "build"}},
+		{http.MethodPatch, "/dev/messages/build", map[string]interface{}{"text": "Build finished"}},
+		{http.MethodDelete, "/dev/messages/build", nil},
+		{http.MethodPost, "/dev", map[string]interface{}{"text": "Deploy started"}},
+	} {
+		resp, body := ts.sutRequest(request.method, _testAPIURL+request.url, request.message)
+		resp.Body.Close()
+		ts.Require().Less(resp.StatusCode, http.StatusBadRequest, body)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Every record exceeds the max size, so the file is rotated before every write.
+	records := []map[string]interface{}{}
+	for _, name := range []string{path + ".3", path + ".2", path + ".1", path} {
+		content, err := os.ReadFile(name)
+		ts.Require().NoError(err)
+		record := map[string]interface{}{}
+		ts.Require().NoError(json.Unmarshal(content, &record))
+		delete(record, "at")
+		records = append(records, record)
+	}
+
+	encoded, err := json.Marshal(records)
+	ts.Require().NoError(err)
+	ts.JSONEq(`[
+		{"operation": "send", "ids": [1], "message": {"text": "Build started", "key": "build", "disableWebPagePreview": 0, "disableNotifications": 0}},
+		{"operation": "edit", "ids": [1], "message": {"text": "Build finished", "disableWebPagePreview": 0, "disableNotifications": 0}},
+		{"operation": "delete", "ids": [1]},
+		{"operation": "send", "ids": [2], "message": {"text": "Deploy started", "disableWebPagePreview": 0, "disableNotifications": 0}}
+	]`, string(encoded))
+
+	logger := zerolog.New(io.Discard)
+	_, err = channels.BuildChannelsFromURLS([]string{"file://" + path}, &logger)
+	ts.ErrorIs(err, channels.ErrSinkInvalidOptions)
+}
@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/rotisserie/eris"
+
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/channels"
+)
+
+var ErrNoRoute = eris.New("No channels matched the labels")
+
+type routeLabels struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// onRoute sends the message to the channels chosen by the routing rules for the message labels.
+func (api *HTTPAPI) onRoute(w http.ResponseWriter, r *http.Request) {
+	decoder, err := newMessageDecoder(r)
+	if err != nil {
+		api.renderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	labels := &routeLabels{}
+	if err := decoder.DecodeJSON(labels); err != nil {
+		api.renderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	targets := api.resolveRoute(labels.Labels)
+	if len(targets) == 0 {
+		api.renderError(w, r, ErrNoRoute, errorStatusCode(ErrNoRoute))
+		return
+	}
+
+	results := channels.EnqueueToChannels(targets, decoder.newMessage)
+	api.renderFanOutResults(w, r, results)
+}
+
+// onRouteDryRun shows the channels which would receive a message with the labels.
+func (api *HTTPAPI) onRouteDryRun(w http.ResponseWriter, r *http.Request) {
+	decoder, err := newMessageDecoder(r)
+	if err != nil {
+		api.renderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	labels := &routeLabels{}
+	if err := decoder.DecodeJSON(labels); err != nil {
+		api.renderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	names := []string{}
+	for _, ch := range api.resolveRoute(labels.Labels) {
+		names = append(names, ch.Name())
+	}
+
+	api.renderSuccess(w, r, map[string]interface{}{
+		"routes":   api.routingRules.Resolve(labels.Labels),
+		"channels": names,
+	}, http.StatusOK)
+}
+
+func (api *HTTPAPI) onRouteReload(w http.ResponseWriter, r *http.Request) {
+	if err := api.routingRules.Reload(); err != nil {
+		api.renderError(w, r, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	api.logger.Info().Msg("Routing rules reloaded")
+	api.renderSuccess(w, r, nil, http.StatusOK)
+}
+
+// resolveRoute returns the channels for the labels. Groups are expanded to their members.
+func (api *HTTPAPI) resolveRoute(labels map[string]string) []channels.MessageChannelInterface {
+	result := []channels.MessageChannelInterface{}
+	seen := map[string]bool{}
+	add := func(ch channels.MessageChannelInterface) {
+		if !seen[ch.Name()] {
+			seen[ch.Name()] = true
+			result = append(result, ch)
+		}
+	}
+
+	for _, name := range api.routingRules.Resolve(labels) {
+		if group, ok := api.groupsMap[name]; ok {
+			for _, ch := range group.Members() {
+				add(ch)
+			}
+			continue
+		}
+		if ch, ok := api.channelsMap[name]; ok {
+			add(ch)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,184 @@
+package routing
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/rotisserie/eris"
+)
+
+var (
+	ErrInvalidRules   = eris.New("Invalid routing rules")
+	ErrUnknownChannel = eris.New("Unknown channel in routing rules")
+)
+
+// Route is a node of the routing tree.
+//
+// A message matches the route if all the Match labels are equal and all the MatchRe labels
+// match the anchored regular expressions. Missing labels are matched as empty strings.
+// The children are checked in order and the first matched child wins, unless it has Continue set,
+// then the next siblings are checked too. If no child matches, the route itself is the result.
+// A route without channels delivers to the channels of its parent.
+type Route struct {
+	Match    map[string]string `json:"match,omitempty"`
+	MatchRe  map[string]string `json:"matchRe,omitempty"`
+	Channels []string          `json:"channels,omitempty"`
+	Continue bool              `json:"continue,omitempty"`
+	Routes   []*Route          `json:"routes,omitempty"`
+
+	matchRe map[string]*regexp.Regexp
+}
+
+// ParseRules parses the JSON routing tree. The root route matches all messages and its channels are the default route.
+func ParseRules(data []byte) (*Route, error) {
+	root := &Route{}
+	if err := json.Unmarshal(data, root); err != nil {
+		return nil, eris.Wrap(ErrInvalidRules, err.Error())
+	}
+	if len(root.Match) > 0 || len(root.MatchRe) > 0 {
+		return nil, eris.Wrap(ErrInvalidRules, "the root route can't have matchers")
+	}
+	if err := root.compile(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func LoadRules(path string) (*Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, eris.Wrapf(err, "Failed to read routing rules %s", path)
+	}
+	return ParseRules(data)
+}
+
+func (r *Route) compile() error {
+	r.matchRe = map[string]*regexp.Regexp{}
+	for label, expr := range r.MatchRe {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return eris.Wrapf(ErrInvalidRules, "label %s: %s", label, err)
+		}
+		r.matchRe[label] = re
+	}
+
+	for _, child := range r.Routes {
+		if child == nil {
+			return eris.Wrap(ErrInvalidRules, "empty route")
+		}
+		if err := child.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks that all the channels of the tree are known.
+func (r *Route) Validate(knownChannel func(name string) bool) error {
+	for _, name := range r.Channels {
+		if !knownChannel(name) {
+			return eris.Wrap(ErrUnknownChannel, name)
+		}
+	}
+	for _, child := range r.Routes {
+		if err := child.Validate(knownChannel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve returns the names of the channels for the labels without duplicates.
+func (r *Route) Resolve(labels map[string]string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, name := range r.match(labels, nil) {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func (r *Route) match(labels map[string]string, parentChannels []string) []string {
+	if !r.matches(labels) {
+		return nil
+	}
+
+	channels := r.Channels
+	if len(channels) == 0 {
+		channels = parentChannels
+	}
+
+	result := []string{}
+	matched := false
+	for _, child := range r.Routes {
+		res := child.match(labels, channels)
+		if res == nil {
+			continue
+		}
+		matched = true
+		result = append(result, res...)
+		if !child.Continue {
+			break
+		}
+	}
+
+	if !matched {
+		return append(result, channels...)
+	}
+	return result
+}
+
+func (r *Route) matches(labels map[string]string) bool {
+	for label, value := range r.Match {
+		if labels[label] != value {
+			return false
+		}
+	}
+	for label, re := range r.matchRe {
+		if !re.MatchString(labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Router holds the routing rules loaded from a file and reloads them on demand.
+type Router struct {
+	path         string
+	knownChannel func(name string) bool
+	rules        atomic.Pointer[Route]
+}
+
+func NewRouter(path string, knownChannel func(name string) bool) (*Router, error) {
+	router := &Router{
+		path:         path,
+		knownChannel: knownChannel,
+	}
+	if err := router.Reload(); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// Reload loads the rules from the file. The current rules are kept if the new ones are invalid.
+func (r *Router) Reload() error {
+	rules, err := LoadRules(r.path)
+	if err != nil {
+		return err
+	}
+	if err := rules.Validate(r.knownChannel); err != nil {
+		return err
+	}
+
+	r.rules.Store(rules)
+	return nil
+}
+
+func (r *Router) Resolve(labels map[string]string) []string {
+	return r.rules.Load().Resolve(labels)
+}
@@ -0,0 +1,85 @@
+package routing_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/routing"
+)
+
+const _testRules = `{
+	"channels": ["default"],
+	"routes": [
+		{
+			"match": {"env": "prod"},
+			"channels": ["prod"],
+			"routes": [
+				{"match": {"severity": "critical"}, "channels": ["oncall"], "continue": true},
+				{"matchRe": {"service": "db-.*|pg"}, "channels": ["dba"]},
+				{"match": {"team": "web"}}
+			]
+		},
+		{"matchRe": {"service": "pg"}, "channels": ["dba"]}
+	]
+}`
+
+type routingTestSuite struct {
+	suite.Suite
+}
+
+func TestRouting(t *testing.T) {
+	suite.Run(t, new(routingTestSuite))
+}
+
+func (ts *routingTestSuite) TestResolve() {
+	rules, err := routing.ParseRules([]byte(_testRules))
+	ts.Require().NoError(err)
+
+	for _, tc := range []struct {
+		labels   map[string]string
+		expected []string
+	}{
+		{map[string]string{}, []string{"default"}},
+		{map[string]string{"env": "dev", "service": "pg"}, []string{"dba"}},
+		{map[string]string{"env": "prod"}, []string{"prod"}},
+		{map[string]string{"env": "prod", "team": "web"}, []string{"prod"}},
+		{map[string]string{"env": "prod", "severity": "critical"}, []string{"oncall"}},
+		{map[string]string{"env": "prod", "severity": "critical", "service": "db-1"}, []string{"oncall", "dba"}},
+		{map[string]string{"env": "prod", "service": "db-1", "team": "web"}, []string{"dba"}},
+		{map[string]string{"env": "prod", "service": "mydb-1"}, []string{"prod"}},
+	} {
+		ts.Equal(tc.expected, rules.Resolve(tc.labels), tc.labels)
+	}
+}
+
+func (ts *routingTestSuite) TestInvalidRules() {
+	for _, rules := range []string{
+		`{"channels": ["default"`,
+		`{"match": {"env": "prod"}}`,
+		`{"routes": [{"matchRe": {"service": "("}}]}`,
+	} {
+		_, err := routing.ParseRules([]byte(rules))
+		ts.Require().ErrorIs(err, routing.ErrInvalidRules, rules)
+	}
+}
+
+func (ts *routingTestSuite) TestRouterReload() {
+	path := filepath.Join(ts.T().TempDir(), "rules.json")
+	ts.Require().NoError(os.WriteFile(path, []byte(`{"channels": ["default"]}`), 0o600))
+
+	known := map[string]bool{"default": true, "other": true}
+	router, err := routing.NewRouter(path, func(name string) bool { return known[name] })
+	ts.Require().NoError(err)
+	ts.Equal([]string{"default"}, router.Resolve(nil))
+
+	ts.Require().NoError(os.WriteFile(path, []byte(`{"channels": ["unknown"]}`), 0o600))
+	ts.Require().ErrorIs(router.Reload(), routing.ErrUnknownChannel)
+	ts.Equal([]string{"default"}, router.Resolve(nil))
+
+	ts.Require().NoError(os.WriteFile(path, []byte(`{"channels": ["other"]}`), 0o600))
+	ts.Require().NoError(router.Reload())
+	ts.Equal([]string{"other"}, router.Resolve(nil))
+}
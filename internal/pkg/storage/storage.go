@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/rotisserie/eris"
+)
+
+const stateFileMode = 0o600
+
+// Storage is a simple key-value storage for the state of the channels.
+// Values are grouped into buckets and serialized to JSON.
+type Storage interface {
+	Get(bucket string, key string, value interface{}) (bool, error)
+	Put(bucket string, key string, value interface{}) error
+	Delete(bucket string, key string) error
+	Keys(bucket string) ([]string, error)
+}
+
+type memoryStorage struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]json.RawMessage
+}
+
+// NewMemoryStorage creates a storage which lives until the program exits.
+func NewMemoryStorage() Storage {
+	return &memoryStorage{
+		buckets: map[string]map[string]json.RawMessage{},
+	}
+}
+
+func (s *memoryStorage) Get(bucket string, key string, value interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.buckets[bucket][key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		return false, eris.Wrapf(err, "Failed to decode %s/%s", bucket, key)
+	}
+	return true, nil
+}
+
+func (s *memoryStorage) Put(bucket string, key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return eris.Wrapf(err, "Failed to encode %s/%s", bucket, key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket]; !ok {
+		s.buckets[bucket] = map[string]json.RawMessage{}
+	}
+	s.buckets[bucket][key] = raw
+	return nil
+}
+
+func (s *memoryStorage) Delete(bucket string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.buckets[bucket], key)
+	return nil
+}
+
+func (s *memoryStorage) Keys(bucket string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// fileStorage keeps all data in memory and rewrites the JSON file on every change.
+// It is intended for small amounts of state like topics mappings or offsets.
+type fileStorage struct {
+	memoryStorage
+
+	path    string
+	flushMu sync.Mutex
+}
+
+// _fileStorages are the opened storages by the absolute paths of their files.
+var (
+	_fileStoragesMu sync.Mutex
+	_fileStorages   = map[string]*fileStorage{}
+)
+
+// NewFileStorage creates a storage persisted to the JSON file.
+// The file is created on the first write. The storage of the file is shared by all its users,
+// so they don't overwrite the changes of each other.
+func NewFileStorage(path string) (Storage, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, eris.Wrapf(err, "Invalid state file %s", path)
+	}
+
+	_fileStoragesMu.Lock()
+	defer _fileStoragesMu.Unlock()
+
+	if s, ok := _fileStorages[absPath]; ok {
+		return s, nil
+	}
+
+	s := &fileStorage{
+		memoryStorage: memoryStorage{
+			buckets: map[string]map[string]json.RawMessage{},
+		},
+		path: path,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, eris.Wrapf(err, "Failed to read state file %s", path)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.buckets); err != nil {
+			return nil, eris.Wrapf(err, "Failed to decode state file %s", path)
+		}
+	}
+
+	_fileStorages[absPath] = s
+	return s, nil
+}
+
+func (s *fileStorage) Put(bucket string, key string, value interface{}) error {
+	if err := s.memoryStorage.Put(bucket, key, value); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *fileStorage) Delete(bucket string, key string) error {
+	if err := s.memoryStorage.Delete(bucket, key); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *fileStorage) flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	data, err := json.Marshal(s.buckets)
+	s.mu.Unlock()
+	if err != nil {
+		return eris.Wrapf(err, "Failed to encode state file %s", s.path)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return eris.Wrapf(err, "Failed to write state file %s", s.path)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return eris.Wrapf(err, "Failed to write state file %s", s.path)
+	}
+	if err := tmp.Chmod(stateFileMode); err != nil {
+		tmp.Close()
+		return eris.Wrapf(err, "Failed to write state file %s", s.path)
+	}
+	if err := tmp.Close(); err != nil {
+		return eris.Wrapf(err, "Failed to write state file %s", s.path)
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
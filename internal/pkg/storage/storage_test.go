@@ -0,0 +1,147 @@
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/storage"
+)
+
+type testValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type storageTestSuite struct {
+	suite.Suite
+}
+
+func TestStorage(t *testing.T) {
+	suite.Run(t, new(storageTestSuite))
+}
+
+func (ts *storageTestSuite) TestMemoryStorage() {
+	s := storage.NewMemoryStorage()
+
+	value := &testValue{}
+	found, err := s.Get("topics", "alerts", value)
+	ts.Require().NoError(err)
+	ts.False(found)
+
+	ts.Require().NoError(s.Put("topics", "alerts", &testValue{Name: "Alerts", Count: 1}))
+	ts.Require().NoError(s.Put("topics", "deploys", &testValue{Name: "Deploys", Count: 2}))
+	ts.Require().NoError(s.Put("offsets", "bot", 10))
+
+	found, err = s.Get("topics", "alerts", value)
+	ts.Require().NoError(err)
+	ts.True(found)
+	ts.Equal(&testValue{Name: "Alerts", Count: 1}, value)
+
+	keys, err := s.Keys("topics")
+	ts.Require().NoError(err)
+	ts.Equal([]string{"alerts", "deploys"}, keys)
+
+	ts.Require().NoError(s.Delete("topics", "alerts"))
+	ts.Require().NoError(s.Delete("topics", "unknown"))
+	ts.Require().NoError(s.Delete("unknown", "alerts"))
+	found, err = s.Get("topics", "alerts", value)
+	ts.Require().NoError(err)
+	ts.False(found)
+
+	keys, err = s.Keys("unknown")
+	ts.Require().NoError(err)
+	ts.Empty(keys)
+
+	var wrongType string
+	_, err = s.Get("offsets", "bot", &wrongType)
+	ts.Error(err)
+	ts.Error(s.Put("topics", "invalid", make(chan int)))
+}
+
+func (ts *storageTestSuite) TestFileStorageReload() {
+	path := filepath.Join(ts.T().TempDir(), "state.json")
+
+	s, err := storage.NewFileStorage(path)
+	ts.Require().NoError(err)
+	_, err = os.Stat(path)
+	ts.True(os.IsNotExist(err), "the file is created on the first write")
+
+	ts.Require().NoError(s.Put("topics", "alerts", &testValue{Name: "Alerts", Count: 1}))
+	ts.Require().NoError(s.Put("topics", "deploys", &testValue{Name: "Deploys", Count: 2}))
+	ts.Require().NoError(s.Delete("topics", "deploys"))
+
+	info, err := os.Stat(path)
+	ts.Require().NoError(err)
+	ts.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	// The restarted program reads the state back.
+	data, err := os.ReadFile(path)
+	ts.Require().NoError(err)
+	path = filepath.Join(ts.T().TempDir(), "state.json")
+	ts.Require().NoError(os.WriteFile(path, data, 0o600))
+	s, err = storage.NewFileStorage(path)
+	ts.Require().NoError(err)
+
+	value := &testValue{}
+	found, err := s.Get("topics", "alerts", value)
+	ts.Require().NoError(err)
+	ts.True(found)
+	ts.Equal(&testValue{Name: "Alerts", Count: 1}, value)
+
+	keys, err := s.Keys("topics")
+	ts.Require().NoError(err)
+	ts.Equal([]string{"alerts"}, keys)
+}
+
+func (ts *storageTestSuite) TestFileStorageAtomicWrite() {
+	dir := ts.T().TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	s, err := storage.NewFileStorage(path)
+	ts.Require().NoError(err)
+	for i := 0; i < 10; i++ {
+		ts.Require().NoError(s.Put("offsets", "bot", i))
+	}
+
+	// The temporary files are renamed over the state file, nothing is left in the directory.
+	entries, err := os.ReadDir(dir)
+	ts.Require().NoError(err)
+	ts.Require().Len(entries, 1)
+	ts.Equal("state.json", entries[0].Name())
+
+	data, err := os.ReadFile(path)
+	ts.Require().NoError(err)
+	ts.JSONEq(`{"offsets": {"bot": 9}}`, string(data))
+}
+
+func (ts *storageTestSuite) TestFileStorageShared() {
+	dir := ts.T().TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	// The users of the same file share the storage and don't overwrite the changes of each other.
+	first, err := storage.NewFileStorage(path)
+	ts.Require().NoError(err)
+	second, err := storage.NewFileStorage(filepath.Join(dir, ".", "state.json"))
+	ts.Require().NoError(err)
+
+	ts.Require().NoError(first.Put("topics", "alerts", &testValue{Name: "Alerts", Count: 1}))
+	ts.Require().NoError(second.Put("offsets", "bot", 10))
+
+	data, err := os.ReadFile(path)
+	ts.Require().NoError(err)
+	ts.JSONEq(`{"topics": {"alerts": {"name": "Alerts", "count": 1}}, "offsets": {"bot": 10}}`, string(data))
+}
+
+func (ts *storageTestSuite) TestInvalidStateFile() {
+	path := filepath.Join(ts.T().TempDir(), "state.json")
+	ts.Require().NoError(os.WriteFile(path, []byte("not a json"), 0o600))
+
+	_, err := storage.NewFileStorage(path)
+	ts.Error(err)
+
+	_, err = storage.NewFileStorage(ts.T().TempDir())
+	ts.Error(err)
+}
@@ -0,0 +1,120 @@
+package updates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rotisserie/eris"
+	"github.com/rs/zerolog"
+)
+
+const (
+	callbackTimeout     = 5 * time.Second
+	callbackRetries     = 4
+	callbackMaxWaitTime = 32 * time.Second
+)
+
+// Envelope is the body of the callback request.
+// Type is the kind of the update: message, edited_message, callback_query and so on.
+type Envelope struct {
+	Bot    string          `json:"bot"`
+	Type   string          `json:"type"`
+	Update json.RawMessage `json:"update"`
+}
+
+// Forwarder posts the updates to the callback URLs. Failed requests are retried
+// on the network errors, 429 and 5xx responses.
+type Forwarder struct {
+	httpClient *resty.Client
+	callbacks  []string
+	logger     *zerolog.Logger
+}
+
+func NewForwarder(callbacks []string, timeout time.Duration, logger *zerolog.Logger) *Forwarder {
+	httpClient := resty.New().
+		SetTimeout(timeout).
+		SetRedirectPolicy(resty.NoRedirectPolicy()).
+		SetRetryCount(callbackRetries).
+		SetRetryMaxWaitTime(callbackMaxWaitTime).
+		SetHeader("Content-Type", "application/json").
+		AddRetryCondition(
+			func(r *resty.Response, err error) bool {
+				return r.StatusCode() == http.StatusTooManyRequests || r.StatusCode() >= http.StatusInternalServerError
+			},
+		)
+
+	return &Forwarder{
+		httpClient: httpClient,
+		callbacks:  callbacks,
+		logger:     logger,
+	}
+}
+
+func (f *Forwarder) HTTPClient() *http.Client {
+	return f.httpClient.GetClient()
+}
+
+// Forward posts the update of the bot to the callbacks which aren't in delivered.
+// The successful callbacks are added to delivered, so the retry of the update doesn't post it to them again.
+// The failed callback doesn't stop the posting to the next ones, the first error is returned.
+// The retries of the requests stop when the context is done.
+func (f *Forwarder) Forward(ctx context.Context, bot string, update json.RawMessage, delivered map[string]bool) error {
+	envelope := &Envelope{
+		Bot:    bot,
+		Type:   updateType(update),
+		Update: update,
+	}
+
+	var firstErr error
+	for _, callback := range f.callbacks {
+		if delivered[callback] {
+			continue
+		}
+		if err := f.post(ctx, callback, envelope); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		delivered[callback] = true
+	}
+
+	return firstErr
+}
+
+func (f *Forwarder) post(ctx context.Context, callback string, envelope *Envelope) error {
+	res, err := f.httpClient.R().
+		SetContext(ctx).
+		SetBody(envelope).
+		Post(callback)
+	if err != nil {
+		return eris.Wrapf(err, "Error on forward update to %s", callback)
+	}
+	if res.IsError() {
+		return eris.New(
+			fmt.Sprintf(
+				"Failed request to callback %s: status: %d, body: %s",
+				callback, res.StatusCode(), res.Body(),
+			),
+		)
+	}
+	return nil
+}
+
+// updateType finds the field of the update with the payload.
+func updateType(update json.RawMessage) string {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(update, &fields); err != nil {
+		return ""
+	}
+	for name := range fields {
+		if name != "update_id" {
+			return name
+		}
+	}
+	return ""
+}
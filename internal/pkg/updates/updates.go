@@ -0,0 +1,522 @@
+package updates
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rotisserie/eris"
+	"github.com/rs/zerolog"
+
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/channels"
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/storage"
+)
+
+var (
+	ErrInvalidReceiver = eris.New("Invalid update receiver")
+	ErrGetUpdates      = eris.New("Failed to get updates")
+	ErrInvalidUpdate   = eris.New("Invalid update")
+	ErrBotAPIRequest   = eris.New("Failed request to Bot API")
+)
+
+// Modes of receiving the updates.
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+)
+
+const (
+	offsetsStateBucket   = "updates_offsets"
+	forwardedStateBucket = "updates_forwarded"
+
+	// forwardedUpdatesCap is the number of the last forwarded webhook updates kept to skip their redelivery.
+	forwardedUpdatesCap = 100
+
+	defaultPollTimeout = 30 * time.Second
+	pollRequestMargin  = 10 * time.Second
+	pollRetryDelay     = 1 * time.Second
+
+	// webhookHandleTimeout is below the request timeout of the HTTP API, so the update which is accepted
+	// by the proxy isn't delivered by Telegram again because of the timed out response.
+	webhookHandleTimeout = 4 * time.Second
+
+	defaultMaxAttempts = 5
+)
+
+// _defaultAllowedUpdates are the incoming messages, the replies and the presses of the inline buttons.
+var _defaultAllowedUpdates = []string{"message", "edited_message", "callback_query"}
+
+// Receiver gets the updates of a bot by the getUpdates long polling or by the webhook and forwards them to the callbacks.
+// The offset of the processed updates is kept in the state, so the updates aren't forwarded twice after a restart.
+type Receiver struct {
+	name           string
+	botToken       string
+	mode           string
+	webhookURL     string
+	secret         string
+	apiClient      *resty.Client
+	forwarder      *Forwarder
+	state          storage.Storage
+	pollTimeout    time.Duration
+	allowedUpdates []string
+	logger         *zerolog.Logger
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+
+	// handleMu serializes the updates received by the concurrent webhook requests.
+	handleMu sync.Mutex
+
+	// acks are the channels which handle the presses of the Acknowledge buttons.
+	acks map[string]channels.AcknowledgerInterface
+
+	// pending are the updates which failed to be forwarded to some callbacks, by the update_id.
+	pending     map[int64]*pendingUpdate
+	maxAttempts int
+}
+
+// pendingUpdate is the delivery state of the update between the attempts to forward it.
+type pendingUpdate struct {
+	attempts     int
+	acknowledged bool
+	delivered    map[string]bool
+}
+
+func BuildReceiversFromURLS(urls []string, logger *zerolog.Logger) ([]*Receiver, error) {
+	result := []*Receiver{}
+	names := map[string]bool{}
+	for _, receiverURL := range urls {
+		u, err := url.Parse(receiverURL)
+		if err != nil {
+			return nil, err
+		}
+		receiver, err := NewReceiver(u, logger)
+		if err != nil {
+			return nil, err
+		}
+		if names[receiver.Name()] {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "name %s is already used", receiver.Name())
+		}
+		names[receiver.Name()] = true
+		result = append(result, receiver)
+	}
+	return result, nil
+}
+
+// NewReceiver creates a receiver from the URL:
+// telegram://bot:token@/receiver_name?callback=https://example.com/updates&state=/var/lib/tgp/updates.json.
+// The webhook mode requires the public URL of the webhook endpoint and the secret token:
+// telegram://bot:token@/receiver_name?callback=...&mode=webhook&webhook_url=https://tgp.example.com/updates/receiver_name&secret=XXXX.
+func NewReceiver(receiverURL *url.URL, logger *zerolog.Logger) (*Receiver, error) {
+	if receiverURL.Scheme != "telegram" {
+		return nil, eris.Wrapf(ErrInvalidReceiver, "scheme: %s", receiverURL.Scheme)
+	}
+
+	name := strings.Trim(receiverURL.Path, "/")
+	if name == "" {
+		return nil, eris.Wrap(ErrInvalidReceiver, "name is required")
+	}
+	if receiverURL.User == nil {
+		return nil, eris.Wrapf(ErrInvalidReceiver, "%s: bot token is required", name)
+	}
+
+	query := receiverURL.Query()
+	callbacks := query["callback"]
+	if len(callbacks) == 0 {
+		return nil, eris.Wrapf(ErrInvalidReceiver, "%s: callback is required", name)
+	}
+	for _, callback := range callbacks {
+		if u, err := url.Parse(callback); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "%s: callback: %s", name, callback)
+		}
+	}
+
+	receiver := &Receiver{
+		name:           name,
+		botToken:       receiverURL.User.String(),
+		mode:           ModePolling,
+		state:          storage.NewMemoryStorage(),
+		pollTimeout:    defaultPollTimeout,
+		allowedUpdates: _defaultAllowedUpdates,
+		logger:         logger,
+		pending:        map[int64]*pendingUpdate{},
+		maxAttempts:    defaultMaxAttempts,
+	}
+
+	var err error
+	if path := query.Get("state"); path != "" {
+		if receiver.state, err = storage.NewFileStorage(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if val := query.Get("poll_timeout"); val != "" {
+		if receiver.pollTimeout, err = channels.ParseDurationOption("poll_timeout", val); err != nil {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "%s: %s", name, err)
+		}
+	}
+
+	timeout := callbackTimeout
+	if val := query.Get("timeout"); val != "" {
+		if timeout, err = channels.ParseDurationOption("timeout", val); err != nil {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "%s: %s", name, err)
+		}
+	}
+
+	if val := query.Get("max_attempts"); val != "" {
+		if receiver.maxAttempts, err = strconv.Atoi(val); err != nil || receiver.maxAttempts <= 0 {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "%s: max_attempts: %s", name, val)
+		}
+	}
+
+	if val := query.Get("mode"); val != "" {
+		if val != ModePolling && val != ModeWebhook {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "%s: mode: %s", name, val)
+		}
+		receiver.mode = val
+	}
+	if receiver.mode == ModeWebhook {
+		receiver.webhookURL = query.Get("webhook_url")
+		if u, err := url.Parse(receiver.webhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "%s: webhook_url must be https URL: %s", name, receiver.webhookURL)
+		}
+		receiver.secret = query.Get("secret")
+		if receiver.secret == "" {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "%s: secret is required in the webhook mode", name)
+		}
+	}
+
+	if val := query.Get("allowed_updates"); val != "" {
+		receiver.allowedUpdates = strings.Split(val, ",")
+	}
+
+	apiURL := channels.TelegramBotAPIURL
+	if val := query.Get("api_url"); val != "" {
+		if apiURL, err = channels.ParseAPIURLOption("api_url", val); err != nil {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "%s: %s", name, err)
+		}
+	}
+
+	receiver.apiClient = resty.New().
+		SetBaseURL(fmt.Sprintf("%s/bot%s", apiURL, receiver.botToken)).
+		SetTimeout(receiver.pollTimeout + pollRequestMargin).
+		SetRedirectPolicy(resty.NoRedirectPolicy())
+	receiver.forwarder = NewForwarder(callbacks, timeout, logger)
+
+	// The Bot API and the callbacks are reached by the same proxy and TLS settings as the channels.
+	transport := channels.TransportOptions(receiverURL)
+	for _, client := range []*resty.Client{receiver.apiClient, receiver.forwarder.httpClient} {
+		if err := channels.ConfigureTransport(client, transport); err != nil {
+			return nil, eris.Wrapf(ErrInvalidReceiver, "%s: %s", name, err)
+		}
+	}
+
+	return receiver, nil
+}
+
+func (r *Receiver) Name() string {
+	return r.name
+}
+
+// HTTPClient returns the client of the Bot API.
+func (r *Receiver) HTTPClient() *http.Client {
+	return r.apiClient.GetClient()
+}
+
+func (r *Receiver) Forwarder() *Forwarder {
+	return r.forwarder
+}
+
+func (r *Receiver) Mode() string {
+	return r.mode
+}
+
+// Start starts the long polling. The receivers in the webhook mode get the updates by HandleWebhook.
+func (r *Receiver) Start() error {
+	if r.mode != ModePolling {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go r.run(ctx)
+	r.cancel = cancel
+
+	return nil
+}
+
+func (r *Receiver) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+	return nil
+}
+
+// HandleAcks makes the receiver handle the presses of the Acknowledge buttons of the channels messages.
+// Only the channels of the receiver bot are handled, the presses for the other channels are rejected.
+func (r *Receiver) HandleAcks(chs []channels.MessageChannelInterface) {
+	r.handleMu.Lock()
+	defer r.handleMu.Unlock()
+
+	r.acks = map[string]channels.AcknowledgerInterface{}
+	for _, ch := range chs {
+		if acknowledger, ok := ch.(channels.AcknowledgerInterface); ok && acknowledger.SentBy(r.botToken) {
+			r.acks[ch.Name()] = acknowledger
+		}
+	}
+}
+
+// VerifySecret checks the X-Telegram-Bot-Api-Secret-Token header of the webhook request.
+func (r *Receiver) VerifySecret(token string) bool {
+	return r.mode == ModeWebhook && subtle.ConstantTimeCompare([]byte(token), []byte(r.secret)) == 1
+}
+
+// HandleWebhook dispatches the update received by the webhook.
+// The error tells Telegram to deliver the update again.
+func (r *Receiver) HandleWebhook(update json.RawMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookHandleTimeout)
+	defer cancel()
+
+	return r.handle(ctx, update)
+}
+
+// SetWebhook registers the webhook of the bot with the secret token.
+func (r *Receiver) SetWebhook() error {
+	if r.mode != ModeWebhook {
+		return eris.Wrapf(ErrInvalidReceiver, "%s isn't in the webhook mode", r.name)
+	}
+
+	// Telegram sends the next update after the previous one is handled, so the callbacks get them in order.
+	return r.call(context.Background(), "/setWebhook", map[string]interface{}{
+		"url":             r.webhookURL,
+		"secret_token":    r.secret,
+		"allowed_updates": r.allowedUpdates,
+		"max_connections": 1,
+	})
+}
+
+// DeleteWebhook unregisters the webhook of the bot, the pending updates are kept for the polling.
+func (r *Receiver) DeleteWebhook() error {
+	return r.call(context.Background(), "/deleteWebhook", map[string]interface{}{})
+}
+
+// acknowledge acknowledges the message if the update is the press of the Acknowledge button and answers the callback query.
+func (r *Receiver) acknowledge(ctx context.Context, update json.RawMessage) {
+	query := struct {
+		CallbackQuery *struct {
+			ID   string `json:"id"`
+			Data string `json:"data"`
+			From struct {
+				Username  string `json:"username"`
+				FirstName string `json:"first_name"` //nolint:tagliatelle
+				LastName  string `json:"last_name"`  //nolint:tagliatelle
+			} `json:"from"`
+		} `json:"callback_query"` //nolint:tagliatelle
+	}{}
+	if err := json.Unmarshal(update, &query); err != nil || query.CallbackQuery == nil {
+		return
+	}
+	channel, id, ok := channels.ParseAckCallbackData(query.CallbackQuery.Data)
+	if !ok {
+		return
+	}
+
+	by := strings.TrimSpace(query.CallbackQuery.From.FirstName + " " + query.CallbackQuery.From.LastName)
+	if query.CallbackQuery.From.Username != "" {
+		by = "@" + query.CallbackQuery.From.Username
+	}
+
+	answer := "Acknowledged"
+	if acknowledger, ok := r.acks[channel]; !ok {
+		answer = "Unknown channel"
+	} else if err := acknowledger.Acknowledge(id, by, time.Now()); err != nil {
+		r.logger.Error().Msgf("Failed to acknowledge message %s of %s: %s", id, channel, err)
+		answer = "Failed to acknowledge"
+		switch {
+		case eris.Is(err, channels.ErrAlreadyAcknowledged):
+			answer = "Already acknowledged"
+		case eris.Is(err, channels.ErrMessageNotFound):
+			answer = "Message not found"
+		}
+	}
+
+	err := r.call(ctx, "/answerCallbackQuery", map[string]interface{}{
+		"callback_query_id": query.CallbackQuery.ID,
+		"text":              answer,
+	})
+	if err != nil {
+		r.logger.Error().Msgf("Failed to answer callback query %s: %s", query.CallbackQuery.ID, err)
+	}
+}
+
+func (r *Receiver) call(ctx context.Context, method string, params map[string]interface{}) error {
+	res, err := r.apiClient.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(params).
+		Post(method)
+	if err != nil {
+		return eris.Wrapf(err, "Error on call %s", method)
+	}
+	if res.IsError() {
+		return eris.Wrapf(ErrBotAPIRequest, "%s: status: %d, body: %s", method, res.StatusCode(), res.Body())
+	}
+	return nil
+}
+
+func (r *Receiver) run(ctx context.Context) {
+	for {
+		err := r.poll(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		r.logger.Error().Msgf("Failed to receive updates of %s: %s", r.name, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollRetryDelay):
+		}
+	}
+}
+
+// poll gets the updates after the saved offset and dispatches them in order.
+// The offset is saved after every dispatched update, the failed update is received again by the next poll.
+func (r *Receiver) poll(ctx context.Context) error {
+	var offset int64
+	if _, err := r.state.Get(offsetsStateBucket, r.name, &offset); err != nil {
+		return err
+	}
+
+	res, err := r.apiClient.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(r.pollTimeout.Seconds()),
+			"allowed_updates": r.allowedUpdates,
+		}).
+		Post("/getUpdates")
+	if err != nil {
+		return err
+	}
+
+	response := struct {
+		Ok          bool              `json:"ok"`
+		Description string            `json:"description"`
+		Result      []json.RawMessage `json:"result"`
+	}{}
+	if err := json.Unmarshal(res.Body(), &response); err != nil || !response.Ok {
+		return eris.Wrapf(ErrGetUpdates, "status: %d, body: %s", res.StatusCode(), res.Body())
+	}
+
+	for _, update := range response.Result {
+		if err := r.handle(ctx, update); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handle dispatches the update and saves the offset after it. The updates before the offset are skipped.
+// The webhook updates may come out of order, so they are checked by the list of the last forwarded updates instead.
+func (r *Receiver) handle(ctx context.Context, update json.RawMessage) error {
+	r.handleMu.Lock()
+	defer r.handleMu.Unlock()
+
+	header := struct {
+		UpdateID *int64 `json:"update_id"` //nolint:tagliatelle
+	}{}
+	if err := json.Unmarshal(update, &header); err != nil || header.UpdateID == nil {
+		return eris.Wrapf(ErrInvalidUpdate, "%s", update)
+	}
+	updateID := *header.UpdateID
+
+	var offset int64
+	if _, err := r.state.Get(offsetsStateBucket, r.name, &offset); err != nil {
+		return err
+	}
+	forwarded := []int64{}
+	if _, err := r.state.Get(forwardedStateBucket, r.name, &forwarded); err != nil {
+		return err
+	}
+
+	skip := updateID < offset
+	if r.mode == ModeWebhook {
+		skip = slices.Contains(forwarded, updateID)
+	}
+	if skip {
+		r.logger.Info().Msgf("Update %d of %s is already forwarded", updateID, r.name)
+		return nil
+	}
+
+	if err := r.dispatch(ctx, updateID, update); err != nil {
+		return eris.Wrapf(err, "Error on dispatch update %d", updateID)
+	}
+
+	if r.mode == ModeWebhook {
+		forwarded = append(forwarded, updateID)
+		if len(forwarded) > forwardedUpdatesCap {
+			forwarded = forwarded[len(forwarded)-forwardedUpdatesCap:]
+		}
+		if err := r.state.Put(forwardedStateBucket, r.name, forwarded); err != nil {
+			return err
+		}
+	}
+	if updateID < offset {
+		return nil
+	}
+	return r.state.Put(offsetsStateBucket, r.name, updateID+1)
+}
+
+// dispatch handles the press of the Acknowledge button once and forwards the update to the callbacks.
+// The update is retried only for the failed callbacks. After maxAttempts it's skipped, so it doesn't block the next updates.
+func (r *Receiver) dispatch(ctx context.Context, updateID int64, update json.RawMessage) error {
+	pending, ok := r.pending[updateID]
+	if !ok {
+		pending = &pendingUpdate{delivered: map[string]bool{}}
+		r.pending[updateID] = pending
+	}
+	pending.attempts++
+
+	if !pending.acknowledged {
+		r.acknowledge(ctx, update)
+		pending.acknowledged = true
+	}
+
+	err := r.forwarder.Forward(ctx, r.name, update, pending.delivered)
+	if err != nil && pending.attempts < r.maxAttempts {
+		return err
+	}
+	delete(r.pending, updateID)
+
+	if err != nil {
+		r.logger.Error().Msgf("Update %d of %s is skipped after %d attempts: %s", updateID, r.name, pending.attempts, err)
+		return nil
+	}
+	r.logger.Info().Msgf("Update %d of %s is forwarded", updateID, r.name)
+	return nil
+}
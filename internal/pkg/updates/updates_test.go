@@ -0,0 +1,331 @@
+package updates_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/channels"
+	"github.com/unhandled-exception/tgproxy-go/internal/pkg/updates"
+)
+
+var _testUpdates = []string{
+	`{"update_id": 10, "message": {"message_id": 1, "text": "ack", "reply_to_message": {"message_id": 42}}}`,
+	`{"update_id": 11, "callback_query": {"id": "cq1", "data": "ack"}}`,
+}
+
+// fakeBotAPI returns the test updates after the requested offset and records the calls of the other methods.
+type fakeBotAPI struct {
+	mu      sync.Mutex
+	offsets []int64
+	calls   []string
+}
+
+func (api *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/botbot:token/getUpdates" {
+		body, _ := io.ReadAll(r.Body)
+		api.mu.Lock()
+		api.calls = append(api.calls, r.URL.Path+" "+string(body))
+		api.mu.Unlock()
+		_, _ = w.Write([]byte(`{"ok": true, "result": true}`))
+		return
+	}
+
+	request := struct {
+		Offset int64 `json:"offset"`
+	}{}
+	_ = json.NewDecoder(r.Body).Decode(&request)
+
+	api.mu.Lock()
+	api.offsets = append(api.offsets, request.Offset)
+	api.mu.Unlock()
+
+	result := []json.RawMessage{}
+	for _, update := range _testUpdates {
+		header := struct {
+			UpdateID int64 `json:"update_id"` //nolint:tagliatelle
+		}{}
+		_ = json.Unmarshal([]byte(update), &header)
+		if header.UpdateID >= request.Offset {
+			result = append(result, json.RawMessage(update))
+		}
+	}
+	if len(result) == 0 {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func (api *fakeBotAPI) lastOffset() int64 {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.offsets) == 0 {
+		return -1
+	}
+	return api.offsets[len(api.offsets)-1]
+}
+
+type updatesTestSuite struct {
+	suite.Suite
+}
+
+func TestUpdates(t *testing.T) {
+	suite.Run(t, new(updatesTestSuite))
+}
+
+func (ts *updatesTestSuite) TestForwardUpdates() {
+	botAPI := &fakeBotAPI{}
+	apiServer := httptest.NewServer(botAPI)
+	defer apiServer.Close()
+
+	var mu sync.Mutex
+	calls := 0
+	envelopes := []*updates.Envelope{}
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first request fails and is retried.
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		envelope := &updates.Envelope{}
+		ts.NoError(json.NewDecoder(r.Body).Decode(envelope))
+		envelopes = append(envelopes, envelope)
+	}))
+	defer callback.Close()
+
+	receiverURL := "telegram://bot:token@/alerts?poll_timeout=1" +
+		"&api_url=" + url.QueryEscape(apiServer.URL) +
+		"&callback=" + url.QueryEscape(callback.URL+"/updates") +
+		"&state=" + url.QueryEscape(filepath.Join(ts.T().TempDir(), "updates.json"))
+
+	logger := zerolog.New(io.Discard)
+	receivers, err := updates.BuildReceiversFromURLS([]string{receiverURL}, &logger)
+	ts.Require().NoError(err)
+	ts.Require().NoError(receivers[0].Start())
+
+	time.Sleep(500 * time.Millisecond)
+	ts.Require().NoError(receivers[0].Stop())
+
+	mu.Lock()
+	ts.Equal(3, calls)
+	ts.Require().Len(envelopes, 2)
+	ts.Equal("alerts", envelopes[0].Bot)
+	ts.Equal("message", envelopes[0].Type)
+	ts.JSONEq(_testUpdates[0], string(envelopes[0].Update))
+	ts.Equal("callback_query", envelopes[1].Type)
+	ts.JSONEq(_testUpdates[1], string(envelopes[1].Update))
+	mu.Unlock()
+	ts.Equal(int64(12), botAPI.lastOffset())
+
+	// The restarted receiver continues from the saved offset.
+	botAPI.mu.Lock()
+	botAPI.offsets = nil
+	botAPI.mu.Unlock()
+	receivers, err = updates.BuildReceiversFromURLS([]string{receiverURL}, &logger)
+	ts.Require().NoError(err)
+	ts.Require().NoError(receivers[0].Start())
+	time.Sleep(200 * time.Millisecond)
+	ts.Require().NoError(receivers[0].Stop())
+
+	ts.Equal(int64(12), botAPI.lastOffset())
+	mu.Lock()
+	ts.Equal(3, calls)
+	mu.Unlock()
+}
+
+func (ts *updatesTestSuite) TestFailingCallback() {
+	botAPI := &fakeBotAPI{}
+	apiServer := httptest.NewServer(botAPI)
+	defer apiServer.Close()
+
+	var mu sync.Mutex
+	posts := map[string]int{}
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		posts[r.URL.Path]++
+
+		// The client errors aren't retried by the forwarder.
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/broken" && strings.Contains(string(body), "cq20") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer callback.Close()
+
+	logger := zerolog.New(io.Discard)
+	receivers, err := updates.BuildReceiversFromURLS([]string{
+		"telegram://bot:token@/alerts?mode=webhook&secret=s3cret&max_attempts=3" +
+			"&webhook_url=" + url.QueryEscape("https://tgp.example.com/updates/alerts") +
+			"&callback=" + url.QueryEscape(callback.URL+"/replies") +
+			"&callback=" + url.QueryEscape(callback.URL+"/broken") +
+			"&api_url=" + url.QueryEscape(apiServer.URL),
+	}, &logger)
+	ts.Require().NoError(err)
+	receiver := receivers[0]
+
+	update := json.RawMessage(`{"update_id": 20, "callback_query": {"id": "cq20", "data": "` +
+		channels.AckCallbackData("alerts", "0123456789abcdef") + `"}}`)
+
+	// The update is retried for the broken callback only, then it's skipped.
+	ts.Error(receiver.HandleWebhook(update))
+	ts.Error(receiver.HandleWebhook(update))
+	ts.NoError(receiver.HandleWebhook(update))
+	ts.NoError(receiver.HandleWebhook(update))
+	ts.NoError(receiver.HandleWebhook(json.RawMessage(`{"update_id": 21, "message": {"message_id": 1}}`)))
+
+	mu.Lock()
+	ts.Equal(map[string]int{"/replies": 2, "/broken": 4}, posts)
+	mu.Unlock()
+
+	// The press of the button is answered once.
+	botAPI.mu.Lock()
+	defer botAPI.mu.Unlock()
+	ts.Equal([]string{
+		`/botbot:token/answerCallbackQuery {"callback_query_id":"cq20","text":"Unknown channel"}`,
+	}, botAPI.calls)
+}
+
+func (ts *updatesTestSuite) TestWebhookOutOfOrder() {
+	var mu sync.Mutex
+	forwarded := []string{}
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		envelope := &updates.Envelope{}
+		ts.NoError(json.NewDecoder(r.Body).Decode(envelope))
+		mu.Lock()
+		forwarded = append(forwarded, string(envelope.Update))
+		mu.Unlock()
+	}))
+	defer callback.Close()
+
+	receiverURL := "telegram://bot:token@/alerts?mode=webhook&secret=s3cret" +
+		"&webhook_url=" + url.QueryEscape("https://tgp.example.com/updates/alerts") +
+		"&callback=" + url.QueryEscape(callback.URL) +
+		"&state=" + url.QueryEscape(filepath.Join(ts.T().TempDir(), "updates.json"))
+
+	logger := zerolog.New(io.Discard)
+	receivers, err := updates.BuildReceiversFromURLS([]string{receiverURL}, &logger)
+	ts.Require().NoError(err)
+
+	// The update 11 comes before the update 10, both are forwarded once.
+	ts.Require().NoError(receivers[0].HandleWebhook(json.RawMessage(_testUpdates[1])))
+	ts.Require().NoError(receivers[0].HandleWebhook(json.RawMessage(_testUpdates[0])))
+	ts.Require().NoError(receivers[0].HandleWebhook(json.RawMessage(_testUpdates[1])))
+
+	// The restarted receiver skips the redelivered updates.
+	receivers, err = updates.BuildReceiversFromURLS([]string{receiverURL}, &logger)
+	ts.Require().NoError(err)
+	ts.Require().NoError(receivers[0].HandleWebhook(json.RawMessage(_testUpdates[0])))
+
+	mu.Lock()
+	defer mu.Unlock()
+	ts.Require().Len(forwarded, 2)
+	ts.JSONEq(_testUpdates[1], forwarded[0])
+	ts.JSONEq(_testUpdates[0], forwarded[1])
+}
+
+func (ts *updatesTestSuite) TestProxy() {
+	var mu sync.Mutex
+	proxied := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"ok": true, "result": true}`))
+	}))
+	defer proxy.Close()
+
+	logger := zerolog.New(io.Discard)
+	receivers, err := updates.BuildReceiversFromURLS([]string{
+		"telegram://bot:token@/alerts?mode=webhook&secret=s3cret" +
+			"&webhook_url=" + url.QueryEscape("https://tgp.example.com/updates/alerts") +
+			"&callback=" + url.QueryEscape("http://callback.test/updates") +
+			"&api_url=" + url.QueryEscape("http://api.telegram.test") +
+			"&proxy=" + url.QueryEscape(proxy.URL),
+	}, &logger)
+	ts.Require().NoError(err)
+
+	// The Bot API and the callbacks are requested through the proxy.
+	ts.Require().NoError(receivers[0].HandleWebhook(json.RawMessage(`{"update_id": 30, "callback_query": {"id": "cq30", "data": "` +
+		channels.AckCallbackData("alerts", "0123456789abcdef") + `"}}`)))
+
+	mu.Lock()
+	defer mu.Unlock()
+	ts.Equal([]string{
+		"http://api.telegram.test/botbot:token/answerCallbackQuery",
+		"http://callback.test/updates",
+	}, proxied)
+}
+
+func (ts *updatesTestSuite) TestWebhookCommands() {
+	botAPI := &fakeBotAPI{}
+	apiServer := httptest.NewServer(botAPI)
+	defer apiServer.Close()
+
+	logger := zerolog.New(io.Discard)
+	receivers, err := updates.BuildReceiversFromURLS([]string{
+		"telegram://bot:token@/alerts?mode=webhook&secret=s3cret&allowed_updates=message" +
+			"&webhook_url=" + url.QueryEscape("https://tgp.example.com/updates/alerts") +
+			"&callback=" + url.QueryEscape("https://example.com/replies") +
+			"&api_url=" + url.QueryEscape(apiServer.URL),
+	}, &logger)
+	ts.Require().NoError(err)
+	receiver := receivers[0]
+
+	// The receivers in the webhook mode don't poll the updates.
+	ts.Require().NoError(receiver.Start())
+	time.Sleep(100 * time.Millisecond)
+	ts.Require().NoError(receiver.Stop())
+	ts.Equal(int64(-1), botAPI.lastOffset())
+
+	ts.True(receiver.VerifySecret("s3cret"))
+	ts.False(receiver.VerifySecret("s3cret2"))
+
+	ts.Require().NoError(receiver.SetWebhook())
+	ts.Require().NoError(receiver.DeleteWebhook())
+
+	botAPI.mu.Lock()
+	defer botAPI.mu.Unlock()
+	ts.Equal([]string{
+		`/botbot:token/setWebhook {"allowed_updates":["message"],"max_connections":1,"secret_token":"s3cret","url":"https://tgp.example.com/updates/alerts"}`,
+		`/botbot:token/deleteWebhook {}`,
+	}, botAPI.calls)
+}
+
+func (ts *updatesTestSuite) TestInvalidReceivers() {
+	logger := zerolog.New(io.Discard)
+	for _, receiverURLs := range [][]string{
+		{"slack://bot:token@/alerts?callback=https://example.com"},
+		{"telegram://bot:token@/?callback=https://example.com"},
+		{"telegram:///alerts?callback=https://example.com"},
+		{"telegram://bot:token@/alerts"},
+		{"telegram://bot:token@/alerts?callback=example.com"},
+		{"telegram://bot:token@/alerts?callback=https://example.com&poll_timeout=soon"},
+		{"telegram://bot:token@/alerts?callback=https://example.com&api_url=ftp://example.com"},
+		{"telegram://bot:token@/alerts?callback=https://example.com&proxy=ftp://proxy.local"},
+		{"telegram://bot:token@/alerts?callback=https://example.com", "telegram://bot:token@/alerts?callback=https://example.com"},
+		{"telegram://bot:token@/alerts?callback=https://example.com&max_attempts=0"},
+		{"telegram://bot:token@/alerts?callback=https://example.com&mode=push"},
+		{"telegram://bot:token@/alerts?callback=https://example.com&mode=webhook&webhook_url=https://tgp.example.com/updates/alerts"},
+		{"telegram://bot:token@/alerts?callback=https://example.com&mode=webhook&secret=s3cret&webhook_url=http://tgp.example.com/updates/alerts"},
+	} {
+		_, err := updates.BuildReceiversFromURLS(receiverURLs, &logger)
+		ts.ErrorIs(err, updates.ErrInvalidReceiver, receiverURLs)
+	}
+}